@@ -0,0 +1,234 @@
+package multiplexer
+
+import (
+	"errors"
+	"github.com/bwmarrin/discordgo"
+	"sync"
+	"time"
+)
+
+// ErrAwaitTimeout represents the error returned when nothing matching arrived before an await timed out.
+var ErrAwaitTimeout = errors.New("await timed out")
+
+// ErrAwaitCancelled represents the error returned when an await is cancelled by Shutdown.
+var ErrAwaitCancelled = errors.New("await cancelled")
+
+// ErrTooManyWaiters represents the error returned when a user has too many pending awaits.
+var ErrTooManyWaiters = errors.New("too many pending awaits")
+
+// DefaultMaxWaiters is the amount of pending awaits allowed per user if not configured.
+const DefaultMaxWaiters = 3
+
+// waiter is a pending await of a message or reaction of a user.
+type waiter struct {
+	userID        string
+	channelID     string
+	messageID     string
+	matchMessage  func(message *discordgo.Message) bool
+	matchReaction func(reaction *discordgo.MessageReaction) bool
+	// ready is closed once message or reaction is set, or the waiter is cancelled.
+	ready     chan struct{}
+	message   *discordgo.Message
+	reaction  *discordgo.MessageReaction
+	cancelled bool
+}
+
+// isServed checks if the waiter has been served.
+func (waiter *waiter) isServed() bool {
+	select {
+	case <-waiter.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// waiterRegistry delivers messages and reactions to pending awaits in registration order.
+type waiterRegistry struct {
+	mutex   sync.Mutex
+	waiters []*waiter
+	pending map[string]int
+	stopped bool
+}
+
+// add registers a waiter unless its user already has max pending ones, DefaultMaxWaiters if zero,
+// or the registry is stopped.
+func (registry *waiterRegistry) add(waiter *waiter, max int) error {
+	if max == 0 {
+		max = DefaultMaxWaiters
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.stopped {
+		return ErrAwaitCancelled
+	}
+	if registry.pending == nil {
+		registry.pending = make(map[string]int)
+	}
+	if registry.pending[waiter.userID] >= max {
+		return ErrTooManyWaiters
+	}
+	registry.pending[waiter.userID]++
+	registry.waiters = append(registry.waiters, waiter)
+	return nil
+}
+
+// stop cancels all pending waiters and refuses new ones.
+func (registry *waiterRegistry) stop() {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.stopped = true
+	for _, waiter := range registry.waiters {
+		waiter.cancelled = true
+		close(waiter.ready)
+	}
+	registry.waiters = nil
+	registry.pending = nil
+}
+
+// remove unregisters a waiter, the mutex must be held.
+func (registry *waiterRegistry) remove(index int) {
+	waiter := registry.waiters[index]
+	registry.waiters = append(registry.waiters[:index:index], registry.waiters[index+1:]...)
+	if registry.pending[waiter.userID]--; registry.pending[waiter.userID] <= 0 {
+		delete(registry.pending, waiter.userID)
+	}
+}
+
+// cancel unregisters a waiter and returns whether it was still pending.
+func (registry *waiterRegistry) cancel(waiter *waiter) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	return registry.take(waiter)
+}
+
+// take unregisters a waiter and returns whether it was still pending, the mutex must be held.
+func (registry *waiterRegistry) take(waiter *waiter) bool {
+	for i, pending := range registry.waiters {
+		if pending == waiter {
+			registry.remove(i)
+			return true
+		}
+	}
+	return false
+}
+
+// deliver serves the first waiter selected by candidate whose filter accepts an event and returns whether one was served.
+// Filters are called without holding the mutex, skipping waiters no longer pending once accepted.
+func (registry *waiterRegistry) deliver(candidate, accept func(waiter *waiter) bool, serve func(waiter *waiter)) bool {
+	registry.mutex.Lock()
+	var candidates []*waiter
+	for _, waiter := range registry.waiters {
+		if candidate(waiter) {
+			candidates = append(candidates, waiter)
+		}
+	}
+	registry.mutex.Unlock()
+
+	for _, waiter := range candidates {
+		if !accept(waiter) {
+			continue
+		}
+		registry.mutex.Lock()
+		taken := registry.take(waiter)
+		if taken {
+			serve(waiter)
+			close(waiter.ready)
+		}
+		registry.mutex.Unlock()
+		if taken {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverMessage hands a message to the first waiter accepting it and returns whether one did.
+func (registry *waiterRegistry) deliverMessage(message *discordgo.Message) bool {
+	return registry.deliver(func(waiter *waiter) bool {
+		return waiter.matchMessage != nil && waiter.userID == message.Author.ID && waiter.channelID == message.ChannelID
+	}, func(waiter *waiter) bool {
+		return waiter.matchMessage(message)
+	}, func(waiter *waiter) {
+		waiter.message = message
+	})
+}
+
+// deliverReaction hands a reaction to the first waiter accepting it and returns whether one did.
+func (registry *waiterRegistry) deliverReaction(reaction *discordgo.MessageReaction) bool {
+	return registry.deliver(func(waiter *waiter) bool {
+		return waiter.matchReaction != nil && waiter.userID == reaction.UserID && waiter.messageID == reaction.MessageID
+	}, func(waiter *waiter) bool {
+		return waiter.matchReaction(reaction)
+	}, func(waiter *waiter) {
+		waiter.reaction = reaction
+	})
+}
+
+// await registers a waiter for the user causing the event, as returned by Actor, and blocks until it is served, the timeout passes or
+// the context is done. A zero timeout waits until the context is done.
+// Awaits are cancelled with ErrAwaitCancelled by Shutdown.
+func (context *Context) await(waiter *waiter, timeout time.Duration) error {
+	actor := context.Actor()
+	if actor == nil {
+		return ErrUserNotFound
+	}
+	waiter.userID = actor.ID
+	mux := context.Multiplexer
+	if err := mux.waiters.add(waiter, mux.maxWaiters); err != nil {
+		return err
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-waiter.ready:
+	case <-expired:
+		mux.waiters.cancel(waiter)
+		if !waiter.isServed() {
+			return ErrAwaitTimeout
+		}
+	case <-context.Done():
+		mux.waiters.cancel(waiter)
+		if !waiter.isServed() {
+			return context.Err()
+		}
+	}
+	if waiter.cancelled {
+		return ErrAwaitCancelled
+	}
+	return nil
+}
+
+// AwaitMessage waits for the next message of the user causing the event in the current channel accepted by filter,
+// a nil filter accepting any. Messages delivered to an await are not routed as commands.
+// The filter is called on the gateway goroutine and must not block. Without a dispatcher and with
+// Session.SyncEvents set, handlers run on that goroutine too, so awaiting from them never receives anything.
+// It returns ErrAwaitTimeout once timeout passes, zero waiting until the context is done, or the error of the context.
+func (context *Context) AwaitMessage(filter func(message *discordgo.Message) bool, timeout time.Duration) (*discordgo.Message, error) {
+	if filter == nil {
+		filter = func(*discordgo.Message) bool { return true }
+	}
+	waiter := &waiter{channelID: context.channelID(), matchMessage: filter, ready: make(chan struct{})}
+	if err := context.await(waiter, timeout); err != nil {
+		return nil, err
+	}
+	return waiter.message, nil
+}
+
+// AwaitReaction waits for the next reaction of the user causing the event added to a message accepted by filter,
+// a nil filter accepting any. It returns errors like AwaitMessage.
+func (context *Context) AwaitReaction(messageID string, filter func(reaction *discordgo.MessageReaction) bool, timeout time.Duration) (*discordgo.MessageReaction, error) {
+	if filter == nil {
+		filter = func(*discordgo.MessageReaction) bool { return true }
+	}
+	waiter := &waiter{messageID: messageID, matchReaction: filter, ready: make(chan struct{})}
+	if err := context.await(waiter, timeout); err != nil {
+		return nil, err
+	}
+	return waiter.reaction, nil
+}
@@ -0,0 +1,85 @@
+package multiplexer
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"testing"
+	"time"
+)
+
+func TestAwaitMessage(t *testing.T) {
+	mux := &Multiplexer{}
+	context := &Context{
+		Multiplexer: mux,
+		User:        &discordgo.User{ID: "user"},
+		Channel:     &discordgo.Channel{ID: "channel"},
+	}
+
+	// Filters run without the registry locked, so they may inspect it
+	result := make(chan *discordgo.Message)
+	go func() {
+		message, err := context.AwaitMessage(func(message *discordgo.Message) bool {
+			mux.waiters.mutex.Lock()
+			defer mux.waiters.mutex.Unlock()
+			return message.Content == "yes"
+		}, time.Second)
+		if err != nil {
+			t.Errorf("await of a zero multiplexer failed, %s", err)
+		}
+		result <- message
+	}()
+	waitPending(mux, 1)
+
+	author := &discordgo.User{ID: "user"}
+	if mux.waiters.deliverMessage(&discordgo.Message{Author: author, ChannelID: "channel", Content: "no"}) {
+		t.Error("message rejected by the filter delivered")
+	}
+	if mux.waiters.deliverMessage(&discordgo.Message{Author: &discordgo.User{ID: "other"}, ChannelID: "channel", Content: "yes"}) {
+		t.Error("message of another user delivered")
+	}
+	if !mux.waiters.deliverMessage(&discordgo.Message{Author: author, ChannelID: "channel", Content: "yes"}) {
+		t.Error("accepted message not delivered")
+	}
+	if message := <-result; message == nil || message.Content != "yes" {
+		t.Errorf("await returned %+v", message)
+	}
+	if mux.waiters.deliverMessage(&discordgo.Message{Author: author, ChannelID: "channel", Content: "yes"}) {
+		t.Error("message delivered to a served await")
+	}
+}
+
+func TestAwaitReactionActor(t *testing.T) {
+	mux := &Multiplexer{}
+	context := &Context{
+		Multiplexer: mux,
+		User:        &discordgo.User{ID: "author"},
+		actor:       &discordgo.User{ID: "reactor"},
+	}
+	result := make(chan error)
+	go func() {
+		_, err := context.AwaitReaction("message", nil, time.Second)
+		result <- err
+	}()
+	waitPending(mux, 1)
+	if mux.waiters.deliverReaction(&discordgo.MessageReaction{UserID: "author", MessageID: "message"}) {
+		t.Error("reaction of the message author delivered")
+	}
+	if !mux.waiters.deliverReaction(&discordgo.MessageReaction{UserID: "reactor", MessageID: "message"}) {
+		t.Error("reaction of the reacting user not delivered")
+	}
+	if err := <-result; err != nil {
+		t.Error(err)
+	}
+}
+
+// waitPending waits until the multiplexer has an amount of pending awaits.
+func waitPending(mux *Multiplexer, amount int) {
+	for {
+		mux.waiters.mutex.Lock()
+		pending := len(mux.waiters.waiters)
+		mux.waiters.mutex.Unlock()
+		if pending == amount {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,278 @@
+package multiplexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrInvalidBlockEntry represents the error returned when a blocklist entry is malformed.
+var ErrInvalidBlockEntry = errors.New("invalid blocklist entry")
+
+// BlockEntry is an entry of the blocklist.
+type BlockEntry struct {
+	// Scope is the kind of entity blocked, one of ScopeUser, ScopeGuild and ScopeChannel.
+	Scope Scope `json:"scope"`
+	// ID is the ID of the blocked entity.
+	ID string `json:"id"`
+	// Reason is the reason of the block.
+	Reason string `json:"reason,omitempty"`
+	// Actor is the ID of the user who created the block.
+	Actor string `json:"actor,omitempty"`
+	// Expiry is the time the block expires, the zero value never expires.
+	Expiry time.Time `json:"expiry,omitempty"`
+}
+
+// Expired checks if the entry expired at a time.
+func (entry BlockEntry) Expired(now time.Time) bool {
+	return !entry.Expiry.IsZero() && !now.Before(entry.Expiry)
+}
+
+// BlocklistStore persists the blocklist.
+type BlocklistStore interface {
+	// LoadBlocklist returns persisted entries.
+	LoadBlocklist() ([]BlockEntry, error)
+	// SaveBlocklist replaces persisted entries.
+	SaveBlocklist(entries []BlockEntry) error
+}
+
+// JSONFileBlocklistStore is a BlocklistStore persisting entries in a JSON file.
+type JSONFileBlocklistStore struct {
+	// Path is the path of the JSON file, created on first save.
+	Path string
+}
+
+// NewJSONFileBlocklistStore returns a BlocklistStore persisting to the JSON file at path.
+func NewJSONFileBlocklistStore(path string) *JSONFileBlocklistStore {
+	return &JSONFileBlocklistStore{Path: path}
+}
+
+// LoadBlocklist returns persisted entries, none if the file does not exist.
+func (store *JSONFileBlocklistStore) LoadBlocklist() ([]BlockEntry, error) {
+	data, err := ioutil.ReadFile(store.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []BlockEntry
+	return entries, json.Unmarshal(data, &entries)
+}
+
+// SaveBlocklist atomically replaces the JSON file with entries.
+func (store *JSONFileBlocklistStore) SaveBlocklist(entries []BlockEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.Path, data)
+}
+
+// blocklist is the in-memory index of the blocklist.
+type blocklist struct {
+	mutex   sync.RWMutex
+	entries map[Scope]map[string]BlockEntry
+}
+
+// lookup returns the unexpired entry of an entity.
+func (list *blocklist) lookup(scope Scope, id string, now time.Time) (BlockEntry, bool) {
+	if id == "" {
+		return BlockEntry{}, false
+	}
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	entry, ok := list.entries[scope][id]
+	if !ok || entry.Expired(now) {
+		return BlockEntry{}, false
+	}
+	return entry, true
+}
+
+// put adds or replaces an entry.
+func (list *blocklist) put(entry BlockEntry) {
+	if list.entries == nil {
+		list.entries = make(map[Scope]map[string]BlockEntry)
+	}
+	if list.entries[entry.Scope] == nil {
+		list.entries[entry.Scope] = make(map[string]BlockEntry)
+	}
+	list.entries[entry.Scope][entry.ID] = entry
+}
+
+// snapshot returns a copy of all entries, including expired ones.
+func (list *blocklist) snapshot() map[Scope]map[string]BlockEntry {
+	entries := make(map[Scope]map[string]BlockEntry, len(list.entries))
+	for scope, scoped := range list.entries {
+		entries[scope] = make(map[string]BlockEntry, len(scoped))
+		for id, entry := range scoped {
+			entries[scope][id] = entry
+		}
+	}
+	return entries
+}
+
+// slice returns unexpired entries sorted by scope and ID, and prunes expired ones.
+func (list *blocklist) slice(now time.Time) []BlockEntry {
+	var entries []BlockEntry
+	for _, scoped := range list.entries {
+		for id, entry := range scoped {
+			if entry.Expired(now) {
+				delete(scoped, id)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Scope != entries[j].Scope {
+			return entries[i].Scope < entries[j].Scope
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+// loadBlocklist loads entries persisted in the configured store.
+func (mux *Multiplexer) loadBlocklist() error {
+	if mux.blocklistStore == nil {
+		return nil
+	}
+	entries, err := mux.blocklistStore.LoadBlocklist()
+	if err != nil {
+		return err
+	}
+	mux.blocklist.mutex.Lock()
+	defer mux.blocklist.mutex.Unlock()
+	for _, entry := range entries {
+		mux.blocklist.put(entry)
+	}
+	return nil
+}
+
+// Block adds an entity to the blocklist and persists the change, replacing an existing entry.
+// Entries expiring in the past are rejected. Actor of the entry is recorded in the log.
+func (mux *Multiplexer) Block(entry BlockEntry) error {
+	switch entry.Scope {
+	case ScopeUser, ScopeGuild, ScopeChannel:
+	default:
+		return fmt.Errorf("%w: unknown scope %q", ErrInvalidBlockEntry, entry.Scope)
+	}
+	if entry.ID == "" {
+		return fmt.Errorf("%w: empty ID", ErrInvalidBlockEntry)
+	}
+	if entry.Expired(time.Now()) {
+		return fmt.Errorf("%w: expiry %s is in the past", ErrInvalidBlockEntry, entry.Expiry.Format(time.RFC3339))
+	}
+
+	mux.blocklist.mutex.Lock()
+	defer mux.blocklist.mutex.Unlock()
+	// Saving prunes expired entries, restore them along with the change if it fails
+	previous := mux.blocklist.snapshot()
+	mux.blocklist.put(entry)
+	if err := mux.saveBlocklist(); err != nil {
+		mux.blocklist.entries = previous
+		return err
+	}
+	expiry := "never"
+	if !entry.Expiry.IsZero() {
+		expiry = entry.Expiry.Format(time.RFC3339)
+	}
+	mux.Logger().Infof("Blocked %s %s by %s, expires %s, reason: %s",
+		entry.Scope, entry.ID, actorName(entry.Actor), expiry, entry.Reason)
+	return nil
+}
+
+// Unblock removes an entity from the blocklist and persists the change.
+func (mux *Multiplexer) Unblock(actor string, scope Scope, id string) error {
+	mux.blocklist.mutex.Lock()
+	defer mux.blocklist.mutex.Unlock()
+	if _, existed := mux.blocklist.entries[scope][id]; !existed {
+		return nil
+	}
+	previous := mux.blocklist.snapshot()
+	delete(mux.blocklist.entries[scope], id)
+	if err := mux.saveBlocklist(); err != nil {
+		mux.blocklist.entries = previous
+		return err
+	}
+	mux.Logger().Infof("Unblocked %s %s by %s", scope, id, actorName(actor))
+	return nil
+}
+
+// Blocklist returns unexpired entries of the blocklist.
+func (mux *Multiplexer) Blocklist() []BlockEntry {
+	mux.blocklist.mutex.Lock()
+	defer mux.blocklist.mutex.Unlock()
+	return mux.blocklist.slice(time.Now())
+}
+
+// saveBlocklist persists unexpired entries, the blocklist mutex must be held.
+func (mux *Multiplexer) saveBlocklist() error {
+	entries := mux.blocklist.slice(time.Now())
+	if mux.blocklistStore == nil {
+		return nil
+	}
+	return mux.blocklistStore.SaveBlocklist(entries)
+}
+
+// Blocked checks if a user, guild or channel is blocked, empty IDs are ignored.
+func (mux *Multiplexer) Blocked(userID, guildID, channelID string) bool {
+	now := time.Now()
+	for _, entity := range [...]struct {
+		scope Scope
+		id    string
+	}{
+		{ScopeUser, userID},
+		{ScopeGuild, guildID},
+		{ScopeChannel, channelID},
+	} {
+		if _, ok := mux.blocklist.lookup(entity.scope, entity.id, now); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocked checks if the user, guild or channel of the context, or the reacting user of reaction events, is blocked.
+func (context *Context) Blocked() bool {
+	var userID string
+	if context.User != nil {
+		userID = context.User.ID
+	}
+	if context.actor != nil && context.Multiplexer.Blocked(context.actor.ID, "", "") {
+		return true
+	}
+	return context.Multiplexer.Blocked(userID, context.guildID(), context.channelID())
+}
+
+// Block adds an entity to the blocklist on behalf of the context user, who must be an operator.
+// A zero duration never expires, negative durations are rejected.
+func (context *Context) Block(scope Scope, id, reason string, duration time.Duration) error {
+	if !context.IsOperator() {
+		return ErrPermissionDenied
+	}
+	entry := BlockEntry{Scope: scope, ID: id, Reason: reason, Actor: context.User.ID}
+	if duration != 0 {
+		entry.Expiry = time.Now().Add(duration)
+	}
+	return context.Multiplexer.Block(entry)
+}
+
+// Unblock removes an entity from the blocklist on behalf of the context user, who must be an operator.
+func (context *Context) Unblock(scope Scope, id string) error {
+	if !context.IsOperator() {
+		return ErrPermissionDenied
+	}
+	return context.Multiplexer.Unblock(context.User.ID, scope, id)
+}
+
+// hookBlocked checks if hooks should skip the context because it is blocked.
+func (mux *Multiplexer) hookBlocked(context *Context) bool {
+	return mux.blockHooks && context.Blocked()
+}
@@ -15,3 +15,27 @@ var (
 	SystemCategory = NewCategory("System",
 		"System-related utilities.")
 )
+
+// DefaultCategories returns copies of the predefined command categories in their default order.
+// Each multiplexer registers its own copies, routes of a predefined category join the copy of the same title.
+func DefaultCategories() []*CommandCategory {
+	var categories []*CommandCategory
+	for _, category := range []*CommandCategory{
+		AudioCategory,
+		ExperienceCategory,
+		ManualsCategory,
+		MediaCategory,
+		ModerationCategory,
+		SystemCategory,
+	} {
+		categories = append(categories, category.copy())
+	}
+	return categories
+}
+
+// copy returns a copy of the category without its routes.
+func (category *CommandCategory) copy() *CommandCategory {
+	copied := *category
+	copied.Routes = nil
+	return &copied
+}
@@ -0,0 +1,30 @@
+package multiplexer
+
+import "testing"
+
+func TestDefaultCategoriesNotShared(t *testing.T) {
+	first, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := first.Route(&Route{Pattern: "play", Category: AudioCategory})
+	if route.Category != first.Category("Audio") || len(route.Category.Routes) != 1 {
+		t.Error("route did not join the registered category")
+	}
+	if routes := second.Category("Audio").Routes; len(routes) != 0 || len(AudioCategory.Routes) != 0 {
+		t.Errorf("route leaked into other multiplexers, %d routes", len(routes))
+	}
+
+	mux, err := NewWithOptions(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Route(&Route{Pattern: "ban", Category: ModerationCategory})
+	if mux.Category("Moderation") == ModerationCategory || len(ModerationCategory.Routes) != 0 {
+		t.Error("predefined category registered instead of a copy")
+	}
+}
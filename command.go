@@ -1,10 +1,10 @@
 package multiplexer
 
 import (
-	"git.randomchars.net/freenitori/log"
 	"github.com/bwmarrin/discordgo"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // NoCommandMatched is called when no command is matched.
@@ -22,6 +22,51 @@ type Route struct {
 	Description   string
 	Category      *CommandCategory
 	Handler       CommandHandler
+	// Privilege is the minimum privilege level required to issue the route.
+	Privilege PrivilegeLevel
+	// Permission is the channel permission required to issue the route, operators are exempt.
+	Permission int
+	// GuildOnly restricts the route to guilds.
+	GuildOnly bool
+	// Essential prevents the route from being disabled in guilds and channels.
+	Essential bool
+	// Timeout is the deadline of the Context passed to the handler, the default command timeout if zero.
+	Timeout time.Duration
+	// Confirm asks the user to confirm before the handler runs, operators may bypass it with the confirm bypass flag.
+	// Without a dispatcher and with Session.SyncEvents set, answers cannot arrive while the handler waits
+	// on the gateway goroutine, so the route is refused instead.
+	Confirm bool
+}
+
+// deniedReply returns the reply for a context not allowed to issue the route, or an empty string if allowed.
+// Overrides are evaluated before the built-in guard, an allowing override exempts the route's Permission.
+func (route *Route) deniedReply(context *Context) string {
+	if route.GuildOnly && context.IsPrivate {
+		return GuildOnly
+	}
+	decision := context.OverrideDecision(route)
+	if decision.Effect == OverrideDeny {
+		return PermissionDenied
+	}
+	if context.PrivilegeLevel() < route.Privilege {
+		switch route.Privilege {
+		case PrivilegeAdministrator:
+			return AdminOnly
+		case PrivilegeOperator:
+			return OperatorOnly
+		default:
+			return PermissionDenied
+		}
+	}
+	if route.Permission != 0 && decision.Effect != OverrideAllow && !context.HasPermission(route.Permission) {
+		return PermissionDenied
+	}
+	return ""
+}
+
+// Allowed checks if overrides and the route's built-in guard allow the context to issue it.
+func (route *Route) Allowed(context *Context) bool {
+	return route.deniedReply(context) == ""
 }
 
 // CommandCategory represents a category of Route.
@@ -29,10 +74,41 @@ type CommandCategory struct {
 	Routes      []*Route
 	Title       string
 	Description string
+	// Hidden excludes the category from listings, for internal commands.
+	Hidden bool
 }
 
-// MatchRoute fuzzy matches a message to a route.
+// Matcher matches message text against routes and returns the route and fields starting at the matched one.
+type Matcher func(routes []*Route, message string) (*Route, []string)
+
+// MatchRoute matches a message to a route with the configured Matcher.
 func (mux *Multiplexer) MatchRoute(message string) (*Route, []string) {
+	if mux.matcher == nil {
+		return FuzzyMatcher(mux.routes, message)
+	}
+	return mux.matcher(mux.routes, message)
+}
+
+// ExactMatcher matches a message to a route by exact pattern or alias.
+func ExactMatcher(routes []*Route, message string) (*Route, []string) {
+	fields := strings.Fields(message)
+	for fieldIndex, fieldIter := range fields {
+		for _, routeIter := range routes {
+			if routeIter.Pattern == fieldIter {
+				return routeIter, fields[fieldIndex:]
+			}
+			for _, aliasPattern := range routeIter.AliasPatterns {
+				if aliasPattern == fieldIter {
+					return routeIter, fields[fieldIndex:]
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// FuzzyMatcher matches a message to a route by exact pattern or alias, falling back to the longest pattern prefix.
+func FuzzyMatcher(routes []*Route, message string) (*Route, []string) {
 	fields := strings.Fields(message)
 	if len(fields) == 0 {
 		return nil, nil
@@ -43,7 +119,7 @@ func (mux *Multiplexer) MatchRoute(message string) (*Route, []string) {
 	var fieldIndex int
 
 	for fieldIndex, fieldIter := range fields {
-		for _, routeIter := range mux.Routes {
+		for _, routeIter := range routes {
 			if routeIter.Pattern == fieldIter {
 				return routeIter, fields[fieldIndex:]
 			}
@@ -65,24 +141,50 @@ func (mux *Multiplexer) MatchRoute(message string) (*Route, []string) {
 
 func (mux *Multiplexer) handleMessageCommand(session *discordgo.Session, create *discordgo.MessageCreate) {
 
+	// Ignore blocked users, guilds and channels
+	if mux.Blocked(create.Author.ID, create.GuildID, create.ChannelID) {
+		return
+	}
+
 	// Ignore self and bot messages
-	if create.Author.ID == session.State.User.ID || create.Author.Bot {
+	if create.Author.ID == session.State.User.ID || (create.Author.Bot && !mux.policy.AllowBots) {
+		return
+	}
+
+	// Hand answers to pending awaits instead of routing them
+	if mux.waiters.deliverMessage(create.Message) {
+		return
+	}
+
+	// Resume wizards interrupted by a restart
+	if mux.resumeWizard(session, create) {
 		return
 	}
 
+	mux.dispatch(EventCommand, func() {
+		mux.routeMessage(session, create)
+	})
+}
+
+// routeMessage makes Context of a message and calls the matching route or not targeted hooks.
+func (mux *Multiplexer) routeMessage(session *discordgo.Session, create *discordgo.MessageCreate) {
+
 	// Make Context
 	context := mux.NewContextMessage(session, create.Message, create)
 	if context == nil {
 		return
 	}
 
-	// Call not targeted hooks and return
+	// Call not targeted hooks and return, on this worker if already running on a pool without a dedicated one
 	if !context.IsTargeted {
-		go func() {
-			for _, hook := range mux.NotTargeted {
-				hook(context)
-			}
-		}()
+		callHooks := func() {
+			mux.runHooks(EventNotTargeted, context)
+		}
+		if mux.dispatcher != nil && !mux.dispatcher.dedicated(EventNotTargeted) {
+			callHooks()
+		} else {
+			mux.dispatch(EventNotTargeted, callHooks)
+		}
 		return
 	}
 
@@ -93,7 +195,7 @@ func (mux *Multiplexer) handleMessageCommand(session *discordgo.Session, create
 	} else {
 		hostName = "\"" + context.Guild.Name + "\""
 	}
-	log.Infof("(Shard %s) \"%s\"@%s > %s",
+	mux.Logger().Infof("(Shard %s) \"%s\"@%s > %s",
 		strconv.Itoa(session.ShardID),
 		context.User.Username+"#"+context.User.Discriminator,
 		hostName,
@@ -104,10 +206,29 @@ func (mux *Multiplexer) handleMessageCommand(session *discordgo.Session, create
 		route, fields := mux.MatchRoute(context.Text)
 		if route != nil {
 			context.Fields = fields
-			route.Handler(context)
+			context.eventType, context.route = EventCommand, route
+			var cancel func()
+			context.ctx, cancel = mux.invocationContext(mux.routeTimeout(route))
+			defer cancel()
+			if !context.RouteEnabled(route) {
+				if !mux.policy.SilentDisabled {
+					context.SendMessage(FeatureDisabled)
+				}
+				return
+			}
+			if reply := route.deniedReply(context); reply != "" {
+				context.SendMessage(reply)
+				return
+			}
+			if route.Confirm && !mux.confirmed(context) {
+				return
+			}
+			mux.runHandler(route, context)
 			return
 		}
 	}
 
-	NoCommandMatched(context)
+	if !mux.policy.SilentNotFound {
+		NoCommandMatched(context)
+	}
 }
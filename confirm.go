@@ -0,0 +1,107 @@
+package multiplexer
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultConfirmTimeout is how long routes requiring confirmation wait for an answer.
+const DefaultConfirmTimeout = 30 * time.Second
+
+// Emojis of confirmation answers.
+const (
+	ConfirmYes = "✅"
+	ConfirmNo  = "❌"
+)
+
+// confirmAnswers maps typed answers to whether they confirm.
+var confirmAnswers = map[string]bool{
+	"yes":     true,
+	"y":       true,
+	"confirm": true,
+	"no":      false,
+	"n":       false,
+	"cancel":  false,
+}
+
+// Confirm sends a prompt in the current channel and waits for the user causing the event to confirm,
+// by reacting with ConfirmYes or ConfirmNo or replying yes or no. Only the user's next message
+// is taken as a reply, so later messages answering someone else reach commands and hooks as usual.
+// It returns ErrAwaitTimeout if no answer arrived in time, and other errors like AwaitMessage.
+func (context *Context) Confirm(prompt string, timeout time.Duration) (bool, error) {
+	sent := context.SendMessage(prompt)
+	if sent == nil {
+		return false, ErrMessageNotSent
+	}
+	// Typed answers still work without permission to add reactions
+	for _, emoji := range []string{ConfirmYes, ConfirmNo} {
+		if err := context.Session.MessageReactionAdd(sent.ChannelID, sent.ID, emoji); err != nil {
+			break
+		}
+	}
+
+	// spoken is set once the user sent a message after the prompt
+	var spoken int32
+	waiter := &waiter{
+		channelID: context.channelID(),
+		messageID: sent.ID,
+		matchMessage: func(message *discordgo.Message) bool {
+			if atomic.SwapInt32(&spoken, 1) == 1 {
+				return false
+			}
+			_, ok := confirmAnswers[strings.ToLower(strings.TrimSpace(message.Content))]
+			return ok
+		},
+		matchReaction: func(reaction *discordgo.MessageReaction) bool {
+			return reaction.Emoji.Name == ConfirmYes || reaction.Emoji.Name == ConfirmNo
+		},
+		ready: make(chan struct{}),
+	}
+	err := context.await(waiter, timeout)
+	_ = context.Session.MessageReactionsRemoveAll(sent.ChannelID, sent.ID)
+	if err != nil {
+		return false, err
+	}
+	if waiter.reaction != nil {
+		return waiter.reaction.Emoji.Name == ConfirmYes, nil
+	}
+	return confirmAnswers[strings.ToLower(strings.TrimSpace(waiter.message.Content))], nil
+}
+
+// confirmed asks the context user to confirm a route requiring confirmation and returns whether to run it.
+// Operators passing the bypass flag skip confirmation, the flag is removed from Fields.
+func (mux *Multiplexer) confirmed(context *Context) bool {
+	if mux.confirmBypass != "" && context.IsOperator() {
+		for i := 1; i < len(context.Fields); i++ {
+			if context.Fields[i] == mux.confirmBypass {
+				context.Fields = append(context.Fields[:i:i], context.Fields[i+1:]...)
+				return true
+			}
+		}
+	}
+
+	if mux.dispatcher == nil && context.Session != nil && context.Session.SyncEvents {
+		mux.Logger().Warnf("Route %s requires confirmation, which cannot be answered with synchronous events and no dispatcher",
+			context.route.Pattern)
+		return false
+	}
+
+	ok, err := context.Confirm(ConfirmPrompt, DefaultConfirmTimeout)
+	switch err {
+	case nil:
+	case ErrAwaitTimeout:
+	case ErrMessageNotSent, ErrAwaitCancelled:
+		return false
+	default:
+		if context.Err() == nil {
+			mux.Logger().Warnf("Error confirming route %s, %s", context.route.Pattern, err)
+		}
+		return false
+	}
+	if !ok {
+		context.SendMessage(ConfirmCancelled)
+	}
+	return ok
+}
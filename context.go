@@ -1,25 +1,30 @@
 package multiplexer
 
 import (
+	"context"
 	"errors"
 	"git.randomchars.net/freenitori/embedutil"
-	"git.randomchars.net/freenitori/log"
 	"github.com/bwmarrin/discordgo"
-	"github.com/sirupsen/logrus"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // ErrUserNotFound represents the error returned when a user is not found.
 var ErrUserNotFound = errors.New("user not found")
 
+// ErrRoleNotFound represents the error returned when a role is not found.
+var ErrRoleNotFound = errors.New("role not found")
+
 // GetPrefix is the function used to get a prefix of a guild.
 var GetPrefix = func(context *Context) string {
-	return context.Multiplexer.Prefix
+	return context.Multiplexer.Prefix()
 }
 
-// Context carries an event's information.
+// Context carries an event's information and implements context.Context.
+// It is cancelled when the multiplexer shuts down, long-running handlers should watch Done.
+// Contexts not made by the multiplexer behave like context.Background.
 type Context struct {
 	Multiplexer       *Multiplexer
 	User              *discordgo.User
@@ -36,6 +41,55 @@ type Context struct {
 	HasPrefix         bool
 	HasMention        bool
 	HasLeadingMention bool
+	// Previous is the message before a MessageUpdate or MessageDelete event, nil if not in the message history.
+	Previous *discordgo.Message
+
+	ctx       context.Context
+	lazy      *lazyMessage
+	actor     *discordgo.User
+	eventType EventType
+	route     *Route
+}
+
+// base returns the context.Context of the context, context.Background if none is set.
+func (context *Context) base() context.Context {
+	return orBackground(context.ctx)
+}
+
+// orBackground returns ctx, or context.Background if ctx is nil.
+func orBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// Deadline returns the deadline of the invocation, if any.
+func (context *Context) Deadline() (time.Time, bool) {
+	return context.base().Deadline()
+}
+
+// Done returns a channel closed when the invocation is cancelled or the multiplexer shuts down.
+func (context *Context) Done() <-chan struct{} {
+	return context.base().Done()
+}
+
+// Err returns why Done was closed, nil if it is not.
+func (context *Context) Err() error {
+	return context.base().Err()
+}
+
+// Value returns the value of a key carried by the context.
+func (context *Context) Value(key interface{}) interface{} {
+	return context.base().Value(key)
+}
+
+// Actor returns the user causing the event, which is the reacting user of reaction events and User otherwise.
+func (context *Context) Actor() *discordgo.User {
+	if context.actor != nil {
+		return context.actor
+	}
+	return context.User
 }
 
 var numericalRegex = regexp.MustCompile("[^0-9]+")
@@ -46,15 +100,17 @@ func (Context) NumericalRegex() *regexp.Regexp {
 }
 
 // SendMessage sends a text message in the current channel and returns the message.
+// Text too long for a single message is split or uploaded as a file as configured by WithMessageSplit,
+// the last message sent is returned.
 func (context *Context) SendMessage(message string) *discordgo.Message {
 	permissions, err := context.Session.State.UserChannelPermissions(context.Session.State.User.ID, context.Message.ChannelID)
 	if !(err == nil && (permissions&discordgo.PermissionSendMessages == discordgo.PermissionSendMessages)) {
 		return nil
 	}
 
-	resultMessage, err := context.Session.ChannelMessageSend(context.Message.ChannelID, message)
+	resultMessage, err := context.Multiplexer.sendText(context.Session, context.Message.ChannelID, message, permissions)
 	if err != nil {
-		log.Errorf("Error while sending message to guild %s, %s", context.Message.GuildID, err)
+		context.Multiplexer.Logger().Errorf("Error while sending message to guild %s, %s", context.Message.GuildID, err)
 		_, _ = context.Session.ChannelMessageSend(context.Message.ChannelID,
 			ErrorOccurred)
 		return nil
@@ -84,7 +140,7 @@ func (context *Context) SendEmbed(message string, embed embedutil.Embed) *discor
 		})
 	}
 	if err != nil {
-		log.Errorf("Error while sending embed to guild %s, %s", context.Message.GuildID, err)
+		context.Multiplexer.Logger().Errorf("Error while sending embed to guild %s, %s", context.Message.GuildID, err)
 		_, _ = context.Session.ChannelMessageSend(context.Message.ChannelID,
 			ErrorOccurred)
 		return nil
@@ -92,14 +148,10 @@ func (context *Context) SendEmbed(message string, embed embedutil.Embed) *discor
 	return resultMessage
 }
 
-// HandleError handles a returned error and send the information of it if in debug mode.
+// HandleError passes a returned error to the error reporter, which by default sends the information of it if in debug mode.
 func (context *Context) HandleError(err error) bool {
 	if err != nil {
-		log.Errorf("Error occurred while handling Discord route, %s", err)
-		context.SendMessage(ErrorOccurred)
-		if log.GetLevel() == logrus.DebugLevel {
-			context.SendMessage(err.Error())
-		}
+		context.Multiplexer.reportError(context, ErrorReport{Err: err, EventType: context.eventType, Route: context.route})
 		return false
 	}
 	return true
@@ -107,29 +159,63 @@ func (context *Context) HandleError(err error) bool {
 
 // HasPermission checks a user for a permission.
 func (context *Context) HasPermission(permission int) bool {
+	if context.User == nil || context.Message == nil {
+		return false
+	}
+
 	// Override check for operators and system administrators
-	if context.User.ID == context.Multiplexer.Administrator.ID {
+	if context.IsOperator() {
 		return true
 	}
-	for _, user := range context.Multiplexer.Operator {
-		if context.User.ID == user.ID {
-			return true
-		}
-	}
 
 	// Check against the user
 	permissions, err := context.Session.State.UserChannelPermissions(context.User.ID, context.Message.ChannelID)
 	return err == nil && (int(permissions)&permission == permission)
 }
 
-// IsOperator checks of a user is an operator.
+// IsOperator checks of a user is an operator, including holders of operator roles in the home guild.
 func (context *Context) IsOperator() bool {
-	return context.Multiplexer.IsOperator(context.User.ID)
+	if context.User == nil {
+		return false
+	}
+	return context.Multiplexer.IsOperator(context.User.ID) ||
+		context.Multiplexer.isRoleOperator(context.Session, context.User.ID)
 }
 
-// IsAdministrator checks of a user is the system administrator.
+// IsAdministrator checks of a user is a system administrator.
 func (context *Context) IsAdministrator() bool {
-	return context.Multiplexer.IsAdministrator(context.User.ID)
+	return context.User != nil && context.Multiplexer.IsAdministrator(context.User.ID)
+}
+
+// VisibleCategories returns categories with routes visible to the user, hidden ones are shown to operators.
+func (context *Context) VisibleCategories() []*CommandCategory {
+	level := context.PrivilegeLevel()
+	var categories []*CommandCategory
+	for _, category := range context.Multiplexer.categories {
+		if category.Hidden && level < PrivilegeOperator {
+			continue
+		}
+		if len(context.visibleRoutes(category, level)) > 0 {
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
+
+// VisibleRoutes returns routes of a category enabled in the context, not denied by overrides and the user is privileged to issue.
+func (context *Context) VisibleRoutes(category *CommandCategory) []*Route {
+	return context.visibleRoutes(category, context.PrivilegeLevel())
+}
+
+func (context *Context) visibleRoutes(category *CommandCategory, level PrivilegeLevel) []*Route {
+	var routes []*Route
+	for _, route := range category.Routes {
+		if route.Privilege <= level && context.RouteEnabled(route) &&
+			context.OverrideDecision(route).Effect != OverrideDeny {
+			routes = append(routes, route)
+		}
+	}
+	return routes
 }
 
 // GetMember gets a member from a string representing it.
@@ -240,10 +326,15 @@ func (context *Context) StitchFields(start int) string {
 // Prefix returns the command prefix of a context.
 func (context *Context) Prefix() string {
 	if context.IsPrivate {
-		return context.Multiplexer.Prefix
-	} else {
-		return GetPrefix(context)
+		return context.Multiplexer.Prefix()
+	}
+	if context.Multiplexer.prefixStore != nil {
+		if prefix, ok := context.Multiplexer.prefixStore.GuildPrefix(context.Guild.ID); ok {
+			return prefix
+		}
+		return context.Multiplexer.Prefix()
 	}
+	return GetPrefix(context)
 }
 
 // GetVoiceState returns the voice state of a user if found.
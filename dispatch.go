@@ -0,0 +1,455 @@
+package multiplexer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultPool is the name of the pool shared by event types without a dedicated pool.
+const DefaultPool EventType = "default"
+
+// OverflowPolicy decides what happens to an event submitted to a full queue.
+type OverflowPolicy int
+
+// Overflow policies.
+const (
+	// OverflowBlock waits until the queue has room.
+	// Set Session.SyncEvents for the wait to apply backpressure to the gateway.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop drops the event.
+	OverflowDrop
+)
+
+// Ordering is the mode of ordered event processing.
+type Ordering int
+
+// Ordering modes.
+// Events are only received in gateway order with Session.SyncEvents set, discordgo otherwise calls each
+// handler on a new goroutine and events of a guild or channel reach the multiplexer in no particular order.
+const (
+	// OrderNone processes events concurrently in no particular order.
+	OrderNone Ordering = iota
+	// OrderGuild processes events of the same guild one at a time in the order received.
+	// Events outside guilds are ordered per channel.
+	OrderGuild
+	// OrderChannel processes events of the same channel one at a time in the order received.
+	// Events without a channel are ordered per guild.
+	OrderChannel
+)
+
+// PoolConfig configures a worker pool.
+type PoolConfig struct {
+	// Workers is the amount of goroutines processing events, DefaultPoolConfig.Workers if zero.
+	Workers int
+	// QueueSize is the amount of events waiting for a worker before the pool overflows,
+	// DefaultPoolConfig.QueueSize if zero.
+	QueueSize int
+	// Overflow is the policy applied when the queue is full.
+	Overflow OverflowPolicy
+}
+
+// DefaultPoolConfig is the configuration of pools not configured otherwise.
+var DefaultPoolConfig = PoolConfig{
+	Workers:   64,
+	QueueSize: 1024,
+	Overflow:  OverflowBlock,
+}
+
+// withDefaults fills unset fields of the configuration.
+func (config PoolConfig) withDefaults() PoolConfig {
+	if config.Workers == 0 {
+		config.Workers = DefaultPoolConfig.Workers
+	}
+	if config.QueueSize == 0 {
+		config.QueueSize = DefaultPoolConfig.QueueSize
+	}
+	return config
+}
+
+// validate checks the configuration for invalid values.
+func (config PoolConfig) validate() error {
+	if config.Workers < 0 || config.QueueSize < 0 {
+		return fmt.Errorf("%w: negative pool size", ErrInvalidOption)
+	}
+	if config.Overflow != OverflowBlock && config.Overflow != OverflowDrop {
+		return fmt.Errorf("%w: unknown overflow policy", ErrInvalidOption)
+	}
+	return nil
+}
+
+// DispatcherConfig configures bounded worker pools processing events.
+type DispatcherConfig struct {
+	// Default configures the pool shared by event types without a dedicated pool.
+	Default PoolConfig
+	// Pools configures dedicated pools of event types.
+	// NotTargeted hooks run on the worker processing the EventCommand they originate from,
+	// unless EventNotTargeted has a dedicated pool.
+	Pools map[EventType]PoolConfig
+	// Ordering serializes hook events per guild or channel while different ones are processed concurrently.
+	// Commands are never ordered so long-running routes do not stall their guild.
+	// It keeps gateway order only with Session.SyncEvents set, SessionRegisterHandlers warns otherwise.
+	Ordering Ordering
+}
+
+// validate checks the configuration for invalid values.
+func (config DispatcherConfig) validate() error {
+	if err := config.Default.validate(); err != nil {
+		return err
+	}
+	if config.Ordering < OrderNone || config.Ordering > OrderChannel {
+		return fmt.Errorf("%w: unknown ordering", ErrInvalidOption)
+	}
+	for eventType, pool := range config.Pools {
+		if err := pool.validate(); err != nil {
+			return fmt.Errorf("%s: %w", eventType, err)
+		}
+	}
+	return nil
+}
+
+// PoolStats holds backpressure metrics of a worker pool.
+type PoolStats struct {
+	Workers   int
+	QueueSize int
+	// Queued is the amount of events waiting for a worker.
+	Queued int
+	// Running is the amount of events being processed.
+	Running int64
+	// Submitted is the amount of events accepted.
+	Submitted uint64
+	// Completed is the amount of events processed.
+	Completed uint64
+	// Dropped is the amount of events dropped by OverflowDrop.
+	Dropped uint64
+	// Blocked is the amount of submissions that waited for room by OverflowBlock.
+	Blocked uint64
+}
+
+// workerPool is a bounded pool of goroutines processing queued tasks.
+type workerPool struct {
+	config PoolConfig
+	queue  chan func()
+	// done is closed to stop workers, tasks still queued are not run.
+	done      chan struct{}
+	running   int64
+	submitted uint64
+	completed uint64
+	dropped   uint64
+	blocked   uint64
+}
+
+// newWorkerPool returns a pool with its workers started.
+func newWorkerPool(config PoolConfig) *workerPool {
+	config = config.withDefaults()
+	pool := &workerPool{
+		config: config,
+		queue:  make(chan func(), config.QueueSize),
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < config.Workers; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+// work processes tasks until the pool is stopped.
+func (pool *workerPool) work() {
+	for {
+		select {
+		case <-pool.done:
+			return
+		case task := <-pool.queue:
+			atomic.AddInt64(&pool.running, 1)
+			task()
+			atomic.AddInt64(&pool.running, -1)
+			atomic.AddUint64(&pool.completed, 1)
+		}
+	}
+}
+
+// stopped checks if the pool is stopped.
+func (pool *workerPool) stopped() bool {
+	select {
+	case <-pool.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// trySubmit queues a task if the queue has room and returns whether it was accepted.
+func (pool *workerPool) trySubmit(task func()) bool {
+	if pool.stopped() {
+		return false
+	}
+	select {
+	case pool.queue <- task:
+		atomic.AddUint64(&pool.submitted, 1)
+		return true
+	default:
+		return false
+	}
+}
+
+// submit queues a task according to the overflow policy and returns whether it was accepted.
+// Tasks submitted to a stopped pool are refused.
+func (pool *workerPool) submit(task func()) bool {
+	if pool.stopped() {
+		return false
+	}
+	select {
+	case pool.queue <- task:
+	default:
+		if pool.config.Overflow == OverflowDrop {
+			atomic.AddUint64(&pool.dropped, 1)
+			return false
+		}
+		atomic.AddUint64(&pool.blocked, 1)
+		select {
+		case pool.queue <- task:
+		case <-pool.done:
+			return false
+		}
+	}
+	atomic.AddUint64(&pool.submitted, 1)
+	return true
+}
+
+// stats returns a snapshot of metrics of the pool.
+func (pool *workerPool) stats() PoolStats {
+	return PoolStats{
+		Workers:   pool.config.Workers,
+		QueueSize: pool.config.QueueSize,
+		Queued:    len(pool.queue),
+		Running:   atomic.LoadInt64(&pool.running),
+		Submitted: atomic.LoadUint64(&pool.submitted),
+		Completed: atomic.LoadUint64(&pool.completed),
+		Dropped:   atomic.LoadUint64(&pool.dropped),
+		Blocked:   atomic.LoadUint64(&pool.blocked),
+	}
+}
+
+// keyedTask is a task waiting for earlier tasks of its key.
+type keyedTask struct {
+	pool *workerPool
+	task func()
+	// drop is called instead of task if the task is dropped.
+	drop func()
+}
+
+// keyedQueues runs tasks sharing a key one at a time in submission order.
+// A key present in queues has a task queued or running, later tasks wait in its slice.
+type keyedQueues struct {
+	mutex  sync.Mutex
+	queues map[string][]keyedTask
+}
+
+// submit runs a task after all earlier tasks of the key completed.
+func (keyed *keyedQueues) submit(key string, item keyedTask) {
+	keyed.mutex.Lock()
+	if queue, active := keyed.queues[key]; active {
+		keyed.queues[key] = append(queue, item)
+		keyed.mutex.Unlock()
+		return
+	}
+	keyed.queues[key] = nil
+	keyed.mutex.Unlock()
+	keyed.start(key, item)
+}
+
+// start submits a task to its pool, dropping it and advancing its key if the pool drops it.
+func (keyed *keyedQueues) start(key string, item keyedTask) {
+	if !item.pool.submit(keyed.wrap(key, item)) {
+		item.drop()
+		keyed.advance(key)
+	}
+}
+
+// wrap returns a task running item then advancing its key.
+func (keyed *keyedQueues) wrap(key string, item keyedTask) func() {
+	return func() {
+		item.task()
+		keyed.advance(key)
+	}
+}
+
+// advance starts the next task of a key on its own pool, or releases the key if none is waiting.
+// It runs on a worker, so it never blocks on a full pool: a task that does not fit waits for room
+// on a new goroutine while its key stays held, or is dropped if the pool drops on overflow.
+func (keyed *keyedQueues) advance(key string) {
+	for {
+		keyed.mutex.Lock()
+		queue := keyed.queues[key]
+		if len(queue) == 0 {
+			delete(keyed.queues, key)
+			keyed.mutex.Unlock()
+			return
+		}
+		item := queue[0]
+		keyed.queues[key] = queue[1:]
+		keyed.mutex.Unlock()
+
+		if item.pool.trySubmit(keyed.wrap(key, item)) {
+			return
+		}
+		if item.pool.stopped() {
+			item.drop()
+			continue
+		}
+		if item.pool.config.Overflow == OverflowDrop {
+			atomic.AddUint64(&item.pool.dropped, 1)
+			item.drop()
+			continue
+		}
+		go keyed.start(key, item)
+		return
+	}
+}
+
+// dispatcher routes tasks of each event type to its worker pool.
+type dispatcher struct {
+	mutex   sync.Mutex
+	config  DispatcherConfig
+	pools   map[EventType]*workerPool
+	keyed   keyedQueues
+	stopped bool
+}
+
+// newDispatcher returns a dispatcher creating pools lazily on first use.
+// A zero Default configuration is replaced by DefaultPoolConfig.
+func newDispatcher(config DispatcherConfig) *dispatcher {
+	if config.Default == (PoolConfig{}) {
+		config.Default = DefaultPoolConfig
+	}
+	return &dispatcher{
+		config: config,
+		pools:  make(map[EventType]*workerPool),
+		keyed:  keyedQueues{queues: make(map[string][]keyedTask)},
+	}
+}
+
+// dedicated checks if an event type has a dedicated pool.
+func (dispatcher *dispatcher) dedicated(eventType EventType) bool {
+	_, ok := dispatcher.config.Pools[eventType]
+	return ok
+}
+
+// pool returns the pool processing an event type.
+func (dispatcher *dispatcher) pool(eventType EventType) *workerPool {
+	if _, ok := dispatcher.config.Pools[eventType]; !ok {
+		eventType = DefaultPool
+	}
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	pool, ok := dispatcher.pools[eventType]
+	if !ok {
+		config, dedicated := dispatcher.config.Pools[eventType]
+		if !dedicated {
+			config = dispatcher.config.Default
+		}
+		pool = newWorkerPool(config)
+		if dispatcher.stopped {
+			close(pool.done)
+		}
+		dispatcher.pools[eventType] = pool
+	}
+	return pool
+}
+
+// stop stops workers of all pools once their running tasks return, tasks submitted afterwards are refused.
+// It is safe to call more than once.
+func (dispatcher *dispatcher) stop() {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	if dispatcher.stopped {
+		return
+	}
+	dispatcher.stopped = true
+	for _, pool := range dispatcher.pools {
+		close(pool.done)
+	}
+}
+
+// stats returns metrics of pools created so far.
+func (dispatcher *dispatcher) stats() map[EventType]PoolStats {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	stats := make(map[EventType]PoolStats, len(dispatcher.pools))
+	for eventType, pool := range dispatcher.pools {
+		stats[eventType] = pool.stats()
+	}
+	return stats
+}
+
+// dispatch runs a task of an event type on its worker pool, unless the multiplexer is shutting down,
+// and returns whether the task was accepted.
+// Without a dispatcher, commands run on the calling goroutine and other events on a new goroutine.
+func (mux *Multiplexer) dispatch(eventType EventType, task func()) bool {
+	if !mux.track() {
+		return false
+	}
+	task = mux.tracked(task)
+	if mux.dispatcher == nil {
+		if eventType == EventCommand {
+			task()
+		} else {
+			go task()
+		}
+		return true
+	}
+	if !mux.dispatcher.pool(eventType).submit(task) {
+		mux.inflight.Done()
+		mux.Logger().Debugf("Dropped %s event, queue is full or stopped", eventType)
+		return false
+	}
+	return true
+}
+
+// dispatchOrdered runs a hook task of an event type, after earlier tasks of the same guild or
+// channel if ordering is enabled.
+func (mux *Multiplexer) dispatchOrdered(eventType EventType, guildID, channelID string, task func()) {
+	if mux.dispatcher == nil {
+		mux.dispatch(eventType, task)
+		return
+	}
+
+	var key string
+	switch mux.dispatcher.config.Ordering {
+	case OrderGuild:
+		key = "guild:" + guildID
+		if guildID == "" {
+			key = "channel:" + channelID
+		}
+	case OrderChannel:
+		key = "channel:" + channelID
+		if channelID == "" {
+			key = "guild:" + guildID
+		}
+	}
+	if key == "" || guildID == "" && channelID == "" {
+		mux.dispatch(eventType, task)
+		return
+	}
+
+	if !mux.track() {
+		return
+	}
+	mux.dispatcher.keyed.submit(key, keyedTask{
+		pool: mux.dispatcher.pool(eventType),
+		task: mux.tracked(task),
+		drop: func() {
+			mux.inflight.Done()
+			mux.Logger().Debugf("Dropped %s event, queue is full or stopped", eventType)
+		},
+	})
+}
+
+// DispatchStats returns backpressure metrics of worker pools keyed by event type or DefaultPool,
+// nil if no dispatcher is configured.
+func (mux *Multiplexer) DispatchStats() map[EventType]PoolStats {
+	if mux.dispatcher == nil {
+		return nil
+	}
+	return mux.dispatcher.stats()
+}
@@ -0,0 +1,176 @@
+package multiplexer
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dispatchSequence dispatches numbered tasks of several guilds and returns the order each guild ran them in.
+// The first task of each guild holds until all tasks are dispatched, so tasks running early show up out of order.
+func dispatchSequence(t *testing.T, config DispatcherConfig, guilds, tasks int) map[string][]int {
+	mux, err := New(WithDispatcher(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	hold := make(chan struct{})
+	order := make(map[string][]int)
+	running := make(map[string]bool)
+	for i := 0; i < tasks; i++ {
+		for g := 0; g < guilds; g++ {
+			guildID, i := strconv.Itoa(g), i
+			eventType := EventMessageCreate
+			if i%2 == 1 {
+				eventType = EventMessageDelete
+			}
+			wg.Add(1)
+			mux.dispatchOrdered(eventType, guildID, "channel"+guildID, func() {
+				defer wg.Done()
+				mutex.Lock()
+				if running[guildID] {
+					t.Errorf("guild %s ran tasks concurrently", guildID)
+				}
+				running[guildID] = true
+				mutex.Unlock()
+				if i == 0 {
+					<-hold
+				}
+				mutex.Lock()
+				order[guildID] = append(order[guildID], i)
+				running[guildID] = false
+				mutex.Unlock()
+			})
+		}
+	}
+	close(hold)
+	wg.Wait()
+	return order
+}
+
+func TestDispatchOrdered(t *testing.T) {
+	for _, config := range []DispatcherConfig{
+		{Ordering: OrderGuild},
+		{Ordering: OrderChannel},
+		{Ordering: OrderGuild, Default: PoolConfig{Workers: 2, QueueSize: 1}},
+		{Ordering: OrderGuild, Pools: map[EventType]PoolConfig{EventMessageDelete: {Workers: 1}}},
+	} {
+		for guildID, sequence := range dispatchSequence(t, config, 3, 20) {
+			if len(sequence) != 20 {
+				t.Fatalf("%+v: guild %s ran %d tasks, want 20", config, guildID, len(sequence))
+			}
+			for i, n := range sequence {
+				if n != i {
+					t.Fatalf("%+v: guild %s ran tasks out of order: %v", config, guildID, sequence)
+				}
+			}
+		}
+	}
+}
+
+func TestDispatchOrderedConcurrentGuilds(t *testing.T) {
+	mux, err := New(WithDispatcher(DispatcherConfig{Ordering: OrderGuild}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first guild's task only completes once the second guild's task has run
+	release := make(chan struct{})
+	done := make(chan struct{})
+	mux.dispatchOrdered(EventMessageCreate, "1", "", func() {
+		<-release
+		close(done)
+	})
+	mux.dispatchOrdered(EventMessageCreate, "2", "", func() {
+		close(release)
+	})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("guilds were not processed concurrently")
+	}
+}
+
+func TestDispatchUnordered(t *testing.T) {
+	mux, err := New(WithDispatcher(DispatcherConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without ordering the second task of a guild must not wait for the first
+	release := make(chan struct{})
+	done := make(chan struct{})
+	mux.dispatchOrdered(EventMessageCreate, "1", "", func() {
+		<-release
+		close(done)
+	})
+	mux.dispatchOrdered(EventMessageCreate, "1", "", func() {
+		close(release)
+	})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("events were unexpectedly serialized")
+	}
+}
+
+func TestShutdownDeadlineStopsPools(t *testing.T) {
+	mux, err := New(WithDispatcher(DispatcherConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	mux.dispatch(EventMessageCreate, func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = mux.Shutdown(ctx); err != context.Canceled {
+		t.Fatalf("Shutdown returned %v, want context.Canceled", err)
+	}
+	if !mux.dispatcher.pool(EventMessageCreate).stopped() {
+		t.Error("pool not stopped after Shutdown deadline")
+	}
+	if mux.dispatcher.pool(EventMessageCreate).submit(func() {}) {
+		t.Error("stopped pool accepted a task")
+	}
+}
+
+func TestZeroContext(t *testing.T) {
+	context := &Context{}
+	if context.Done() != nil || context.Err() != nil || context.Value(invocationIDKey) != nil {
+		t.Error("zero Context is not like context.Background")
+	}
+	context.SetTraceID("trace")
+	if context.TraceID() != "trace" {
+		t.Errorf("trace ID %q, want trace", context.TraceID())
+	}
+}
+
+func TestOrderingWithoutSyncEvents(t *testing.T) {
+	for _, sync := range []bool{false, true} {
+		logger := &recordingLogger{}
+		mux, err := New(WithDispatcher(DispatcherConfig{Ordering: OrderGuild}), WithLogger(logger))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux.SessionRegisterHandlers(&discordgo.Session{SyncEvents: sync})
+		if warned := len(logger.warnings) == 1; warned == sync {
+			t.Errorf("SyncEvents %t: warned %t", sync, warned)
+		}
+		mux.release()
+	}
+}
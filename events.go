@@ -0,0 +1,40 @@
+package multiplexer
+
+// EventType identifies a kind of event handled by the multiplexer.
+type EventType string
+
+// Event types handled by the multiplexer.
+const (
+	// EventCommand is the dispatch of a targeted message to a route.
+	EventCommand               EventType = "Command"
+	EventNotTargeted           EventType = "NotTargeted"
+	EventReady                 EventType = "Ready"
+	EventGuildMemberAdd        EventType = "GuildMemberAdd"
+	EventGuildMemberRemove     EventType = "GuildMemberRemove"
+	EventGuildDelete           EventType = "GuildDelete"
+	EventMessageCreate         EventType = "MessageCreate"
+	EventMessageDelete         EventType = "MessageDelete"
+	EventMessageUpdate         EventType = "MessageUpdate"
+	EventMessageReactionAdd    EventType = "MessageReactionAdd"
+	EventMessageReactionRemove EventType = "MessageReactionRemove"
+	EventVoiceStateUpdate      EventType = "VoiceStateUpdate"
+	EventChannelCreate         EventType = "ChannelCreate"
+	EventChannelUpdate         EventType = "ChannelUpdate"
+	EventChannelDelete         EventType = "ChannelDelete"
+	EventGuildCreate           EventType = "GuildCreate"
+	EventGuildUpdate           EventType = "GuildUpdate"
+	EventGuildRoleCreate       EventType = "GuildRoleCreate"
+	EventGuildRoleUpdate       EventType = "GuildRoleUpdate"
+	EventGuildRoleDelete       EventType = "GuildRoleDelete"
+	EventGuildMemberUpdate     EventType = "GuildMemberUpdate"
+	EventGuildBanAdd           EventType = "GuildBanAdd"
+	EventGuildBanRemove        EventType = "GuildBanRemove"
+	EventPresenceUpdate        EventType = "PresenceUpdate"
+	EventTypingStart           EventType = "TypingStart"
+	EventInviteCreate          EventType = "InviteCreate"
+	EventInviteDelete          EventType = "InviteDelete"
+	// Thread events carry the raw *discordgo.Event as Event and the thread as Channel.
+	EventThreadCreate EventType = "ThreadCreate"
+	EventThreadUpdate EventType = "ThreadUpdate"
+	EventThreadDelete EventType = "ThreadDelete"
+)
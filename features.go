@@ -0,0 +1,315 @@
+package multiplexer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownFeature represents the error returned when a name matches no route or category.
+var ErrUnknownFeature = errors.New("unknown feature")
+
+// ErrAmbiguousFeature represents the error returned when a name matches both a route and a category.
+var ErrAmbiguousFeature = errors.New("feature name matches both a route and a category")
+
+// Scope is the kind of entity a setting applies to.
+type Scope string
+
+// Scopes of settings.
+const (
+	ScopeGuild   Scope = "guild"
+	ScopeChannel Scope = "channel"
+	ScopeUser    Scope = "user"
+)
+
+// FeatureMode is the mode of FeatureRules.
+type FeatureMode int
+
+// Modes of FeatureRules.
+const (
+	// FeatureDenyList disables listed routes and categories.
+	FeatureDenyList FeatureMode = iota
+	// FeatureAllowList disables everything except listed routes and categories.
+	FeatureAllowList
+)
+
+// FeatureRules lists route patterns and category titles enabled or disabled in a guild or channel.
+type FeatureRules struct {
+	Mode       FeatureMode `json:"mode"`
+	Routes     []string    `json:"routes,omitempty"`
+	Categories []string    `json:"categories,omitempty"`
+	// AllowedRoutes and AllowedCategories are enabled in FeatureDenyList mode even if disabled in the guild,
+	// they are only set on channels.
+	AllowedRoutes     []string `json:"allowed_routes,omitempty"`
+	AllowedCategories []string `json:"allowed_categories,omitempty"`
+}
+
+// decide checks if the rules enable a route, and returns false for decided if they leave it to the guild.
+// Routes are matched before their category, and rules in FeatureAllowList mode decide on every route.
+func (rules *FeatureRules) decide(route *Route) (allowed, decided bool) {
+	allowList := rules.Mode == FeatureAllowList
+	switch {
+	case containsFold(rules.Routes, route.Pattern):
+		return allowList, true
+	case containsFold(rules.AllowedRoutes, route.Pattern):
+		return true, true
+	}
+	if route.Category != nil {
+		switch {
+		case containsFold(rules.Categories, route.Category.Title):
+			return allowList, true
+		case containsFold(rules.AllowedCategories, route.Category.Title):
+			return true, true
+		}
+	}
+	return false, allowList
+}
+
+// empty checks if the rules change nothing.
+func (rules *FeatureRules) empty() bool {
+	return rules.Mode == FeatureDenyList && len(rules.Routes) == 0 && len(rules.Categories) == 0 &&
+		len(rules.AllowedRoutes) == 0 && len(rules.AllowedCategories) == 0
+}
+
+// copy returns a copy of the rules not sharing lists.
+func (rules FeatureRules) copy() FeatureRules {
+	return FeatureRules{
+		Mode:              rules.Mode,
+		Routes:            append([]string(nil), rules.Routes...),
+		Categories:        append([]string(nil), rules.Categories...),
+		AllowedRoutes:     append([]string(nil), rules.AllowedRoutes...),
+		AllowedCategories: append([]string(nil), rules.AllowedCategories...),
+	}
+}
+
+// FeatureStore is the settings store holding FeatureRules of guilds and channels.
+type FeatureStore interface {
+	// Features returns rules of an entity, nil if none are set.
+	Features(scope Scope, id string) (*FeatureRules, error)
+	// SetFeatures replaces rules of an entity, nil clears them.
+	SetFeatures(scope Scope, id string, rules *FeatureRules) error
+}
+
+// memoryFeatureStore is the in-memory FeatureStore used when none is configured.
+type memoryFeatureStore struct {
+	mutex sync.RWMutex
+	rules map[Scope]map[string]FeatureRules
+}
+
+func (store *memoryFeatureStore) Features(scope Scope, id string) (*FeatureRules, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	rules, ok := store.rules[scope][id]
+	if !ok {
+		return nil, nil
+	}
+	rules = rules.copy()
+	return &rules, nil
+}
+
+func (store *memoryFeatureStore) SetFeatures(scope Scope, id string, rules *FeatureRules) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if rules == nil {
+		delete(store.rules[scope], id)
+		return nil
+	}
+	if store.rules == nil {
+		store.rules = make(map[Scope]map[string]FeatureRules)
+	}
+	if store.rules[scope] == nil {
+		store.rules[scope] = make(map[string]FeatureRules)
+	}
+	store.rules[scope][id] = rules.copy()
+	return nil
+}
+
+// featureStore returns the configured FeatureStore.
+func (mux *Multiplexer) featureStore() FeatureStore {
+	if mux.features == nil {
+		return &mux.defaultFeatures
+	}
+	return mux.features
+}
+
+// Features returns feature rules of a guild or channel, nil if none are set.
+func (mux *Multiplexer) Features(scope Scope, id string) (*FeatureRules, error) {
+	return mux.featureStore().Features(scope, id)
+}
+
+// SetFeatures replaces feature rules of a guild or channel, nil clears them.
+func (mux *Multiplexer) SetFeatures(scope Scope, id string, rules *FeatureRules) error {
+	mux.featureMutex.Lock()
+	defer mux.featureMutex.Unlock()
+	return mux.featureStore().SetFeatures(scope, id, rules)
+}
+
+// EnableFeature enables a route pattern or category title in a guild or channel.
+// Enabling in a channel overrides the guild disabling it.
+// Names matching both a route and a category must be prefixed with "route:" or "category:".
+func (mux *Multiplexer) EnableFeature(scope Scope, id, name string) error {
+	return mux.toggleFeature(scope, id, name, true)
+}
+
+// DisableFeature disables a route pattern or category title in a guild or channel.
+// Names matching both a route and a category must be prefixed with "route:" or "category:".
+func (mux *Multiplexer) DisableFeature(scope Scope, id, name string) error {
+	return mux.toggleFeature(scope, id, name, false)
+}
+
+// resolveFeature returns the route pattern or category title a feature name refers to.
+func (mux *Multiplexer) resolveFeature(name string) (string, bool, error) {
+	var category *CommandCategory
+	var route *Route
+	switch {
+	case strings.HasPrefix(name, "category:"):
+		category = mux.Category(strings.TrimPrefix(name, "category:"))
+	case strings.HasPrefix(name, "route:"):
+		route = mux.routeByPattern(strings.TrimPrefix(name, "route:"))
+	default:
+		category, route = mux.Category(name), mux.routeByPattern(name)
+		if category != nil && route != nil {
+			return "", false, fmt.Errorf("%w: %s", ErrAmbiguousFeature, name)
+		}
+	}
+	switch {
+	case category != nil:
+		return category.Title, true, nil
+	case route != nil:
+		return route.Pattern, false, nil
+	}
+	return "", false, ErrUnknownFeature
+}
+
+// toggleFeature lists or unlists a feature depending on the mode of existing rules.
+func (mux *Multiplexer) toggleFeature(scope Scope, id, name string, enable bool) error {
+	name, isCategory, err := mux.resolveFeature(name)
+	if err != nil {
+		return err
+	}
+
+	mux.featureMutex.Lock()
+	defer mux.featureMutex.Unlock()
+	rules, err := mux.featureStore().Features(scope, id)
+	if err != nil {
+		return err
+	}
+	if rules == nil {
+		rules = &FeatureRules{Mode: FeatureDenyList}
+	}
+
+	list, allowed := &rules.Routes, &rules.AllowedRoutes
+	if isCategory {
+		list, allowed = &rules.Categories, &rules.AllowedCategories
+	}
+	if enable == (rules.Mode == FeatureAllowList) {
+		if !containsFold(*list, name) {
+			*list = append(*list, name)
+		}
+	} else {
+		*list = removeFold(*list, name)
+	}
+	if rules.Mode == FeatureDenyList {
+		*allowed = removeFold(*allowed, name)
+		// Channels record enabled features to override the guild
+		if enable && scope == ScopeChannel {
+			*allowed = append(*allowed, name)
+		}
+	}
+
+	if rules.empty() {
+		rules = nil
+	}
+	return mux.featureStore().SetFeatures(scope, id, rules)
+}
+
+// routeByPattern returns a registered route by its pattern or alias.
+func (mux *Multiplexer) routeByPattern(pattern string) *Route {
+	for _, route := range mux.routes {
+		if route.Pattern == pattern {
+			return route
+		}
+		for _, alias := range route.AliasPatterns {
+			if alias == pattern {
+				return route
+			}
+		}
+	}
+	return nil
+}
+
+// RouteEnabled checks if a route is enabled in the guild and channel of the context.
+// Rules of the channel are checked first, and the most specific rule matching the route decides.
+func (context *Context) RouteEnabled(route *Route) bool {
+	if route.Essential || context.IsPrivate || context.Guild == nil || context.Guild.ID == "" {
+		return true
+	}
+	scopes := []struct {
+		scope Scope
+		id    string
+	}{
+		{ScopeChannel, context.channelID()},
+		{ScopeGuild, context.Guild.ID},
+	}
+	for _, entry := range scopes {
+		if entry.id == "" {
+			continue
+		}
+		rules, err := context.Multiplexer.Features(entry.scope, entry.id)
+		if err != nil {
+			context.Multiplexer.Logger().Errorf("Error getting features of %s %s, %s", entry.scope, entry.id, err)
+			continue
+		}
+		if rules == nil {
+			continue
+		}
+		if allowed, decided := rules.decide(route); decided {
+			return allowed
+		}
+	}
+	return true
+}
+
+// channelID returns the ID of the channel of the context.
+func (context *Context) channelID() string {
+	if context.Channel != nil {
+		return context.Channel.ID
+	}
+	if context.Message != nil {
+		return context.Message.ChannelID
+	}
+	return ""
+}
+
+// guildID returns the ID of the guild of the context, empty if none.
+func (context *Context) guildID() string {
+	if context.Guild != nil {
+		return context.Guild.ID
+	}
+	if context.Message != nil {
+		return context.Message.GuildID
+	}
+	return ""
+}
+
+// containsFold checks if a slice contains a string, case-insensitively.
+func containsFold(slice []string, s string) bool {
+	for _, item := range slice {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeFold returns a slice without a string, case-insensitively.
+func removeFold(slice []string, s string) []string {
+	result := slice[:0]
+	for _, item := range slice {
+		if !strings.EqualFold(item, s) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -53,3 +53,27 @@ func GetChannel(session *discordgo.Session, id string) *discordgo.Channel {
 	}
 	return channel
 }
+
+// GetMember fetches member from cache then API and returns nil if all fails.
+func GetMember(session *discordgo.Session, guildID, userID string) *discordgo.Member {
+	if guildID == "" || userID == "" {
+		return nil
+	}
+
+	member, err := session.State.Member(guildID, userID)
+	if err != nil {
+		// Attempt direct API fetching
+		member, err = session.GuildMember(guildID, userID)
+		if err != nil {
+			log.Errorf("Error fetching member from API or cache, %s", err)
+			return nil
+		}
+		// Attempt caching the member
+		member.GuildID = guildID
+		err = session.State.MemberAdd(member)
+		if err != nil {
+			log.Warnf("Error caching member fetched from API, %s", err)
+		}
+	}
+	return member
+}
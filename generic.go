@@ -1,190 +1,392 @@
 package multiplexer
 
 import (
-	"git.randomchars.net/freenitori/log"
+	"encoding/json"
 	"github.com/bwmarrin/discordgo"
 )
 
 // Event handler that fires when ready
 func (mux *Multiplexer) onReady(session *discordgo.Session, ready *discordgo.Ready) {
-	go func() {
-		for _, hook := range mux.Ready {
-			hook(&Context{
-				Multiplexer: mux,
-				User:        session.State.User,
-				Session:     session,
-				Event:       ready,
-			})
+	mux.dispatch(EventReady, func() {
+		if mux.applicationOwners {
+			mux.loadApplicationOwners(session)
 		}
-	}()
-	return
+		mux.pruneWizards()
+		context := mux.eventContext(session, ready)
+		context.User = session.State.User
+		mux.runHooks(EventReady, context)
+	})
 }
 
 // Event handler that fires when a guild member is added
 func (mux *Multiplexer) onGuildMemberAdd(session *discordgo.Session, add *discordgo.GuildMemberAdd) {
-	go func() {
-		for _, hook := range mux.GuildMemberAdd {
-			guild := GetGuild(session, add.GuildID)
-			if guild == nil {
-				return
-			}
-			hook(&Context{
-				Multiplexer: mux,
-				Member:      add.Member,
-				User:        add.Member.User,
-				Session:     session,
-				Guild:       guild,
-				Event:       add,
-			})
-		}
-	}()
-	return
+	mux.emit(EventGuildMemberAdd, add.GuildID, "", func() *Context {
+		guild := GetGuild(session, add.GuildID)
+		if guild == nil {
+			return nil
+		}
+		context := mux.eventContext(session, add)
+		context.Member = add.Member
+		context.User = add.Member.User
+		context.Guild = guild
+		return context
+	})
 }
 
 // Event handler that fires when a guild member is removed
 func (mux *Multiplexer) onGuildMemberRemove(session *discordgo.Session, remove *discordgo.GuildMemberRemove) {
-	go func() {
-		for _, hook := range mux.GuildMemberRemove {
-			guild := GetGuild(session, remove.GuildID)
-			if guild == nil {
-				return
-			}
-			hook(&Context{
-				Multiplexer: mux,
-				Member:      remove.Member,
-				User:        remove.Member.User,
-				Session:     session,
-				Guild:       guild,
-				Event:       remove,
-			})
-		}
-	}()
-	return
+	mux.emit(EventGuildMemberRemove, remove.GuildID, "", func() *Context {
+		guild := GetGuild(session, remove.GuildID)
+		if guild == nil {
+			return nil
+		}
+		context := mux.eventContext(session, remove)
+		context.Member = remove.Member
+		context.User = remove.Member.User
+		context.Guild = guild
+		return context
+	})
 }
 
 // Event handler that fires when a guild is deleted
 func (mux *Multiplexer) onGuildDelete(session *discordgo.Session, delete *discordgo.GuildDelete) {
-	go func() {
-		for _, hook := range mux.GuildDelete {
-			hook(&Context{
-				Multiplexer: mux,
-				Session:     session,
-				Guild:       delete.Guild,
-				Event:       delete,
-			})
-		}
-	}()
-	return
+	mux.emit(EventGuildDelete, delete.ID, "", func() *Context {
+		context := mux.eventContext(session, delete)
+		mux.place(context, delete.ID, delete.Guild, nil)
+		return context
+	})
 }
 
 // Event handler that fires when a message is created
 func (mux *Multiplexer) onMessageCreate(session *discordgo.Session, create *discordgo.MessageCreate) {
-	go func() {
-		for _, hook := range mux.MessageCreate {
-			context := mux.NewContextMessage(session, create.Message, create)
-			if context == nil {
-				return
-			}
-			hook(context)
-		}
-	}()
-	return
+	if mux.history != nil {
+		mux.history.record(create.Message)
+	}
+	mux.emit(EventMessageCreate, create.GuildID, create.ChannelID, func() *Context {
+		return mux.newContextMessage(session, create.Message, create)
+	})
 }
 
 // Event handler that fires when a message is deleted
+// The message is taken from the message history if recorded.
 func (mux *Multiplexer) onMessageDelete(session *discordgo.Session, delete *discordgo.MessageDelete) {
-	go func() {
-		for _, hook := range mux.MessageDelete {
-			hook(&Context{
-				Multiplexer: mux,
-				Message:     delete.Message,
-				Session:     session,
-				Event:       delete,
-			})
+	var previous *discordgo.Message
+	if mux.history != nil {
+		previous = mux.history.remove(delete.ChannelID, delete.ID)
+	}
+	mux.emit(EventMessageDelete, delete.GuildID, delete.ChannelID, func() *Context {
+		context := mux.eventContext(session, delete)
+		if !mux.place(context, delete.GuildID, nil, GetChannel(session, delete.ChannelID)) {
+			return nil
+		}
+		context.Message = delete.Message
+		if previous != nil {
+			context.Message = previous
+			context.Previous = previous
+			context.User = previous.Author
 		}
-	}()
-	return
+		return context
+	})
 }
 
 // Event handler that fires when a message is updated
+// The message before the update is taken from the message history if recorded.
 func (mux *Multiplexer) onMessageUpdate(session *discordgo.Session, update *discordgo.MessageUpdate) {
-	go func() {
-		for _, hook := range mux.MessageUpdate {
-			hook(&Context{
-				Multiplexer: mux,
-				Message:     update.Message,
-				Session:     session,
-				Event:       update,
-			})
+	var previous *discordgo.Message
+	if mux.history != nil {
+		previous = mux.history.update(update.Message)
+	}
+	mux.emit(EventMessageUpdate, update.GuildID, update.ChannelID, func() *Context {
+		context := mux.eventContext(session, update)
+		if !mux.place(context, update.GuildID, nil, GetChannel(session, update.ChannelID)) {
+			return nil
 		}
-	}()
-	return
+		context.Message = update.Message
+		context.Previous = previous
+		context.User = update.Author
+		if context.User == nil && previous != nil {
+			context.User = previous.Author
+		}
+		return context
+	})
 }
 
-// Event handler that fires when a reaction is added
+// Event handler that fires when a reaction is added, serving awaits and pressing menu buttons
 func (mux *Multiplexer) onMessageReactionAdd(session *discordgo.Session, add *discordgo.MessageReactionAdd) {
-	go func() {
-		for _, hook := range mux.MessageReactionAdd {
-			message, err := session.ChannelMessage(add.ChannelID, add.MessageID)
-			if err != nil {
-				log.Errorf("Error getting message %s from channel %s, %s", add.MessageID, add.ChannelID, err)
-				return
-			}
-			message.GuildID = add.GuildID
-			context := mux.NewContextMessage(session, message, add)
-			if context == nil {
-				return
-			}
-			hook(context)
-		}
-	}()
-	return
+	mux.waiters.deliverReaction(add.MessageReaction)
+	mux.routeMenu(session, add.MessageReaction, add)
+	mux.emit(EventMessageReactionAdd, add.GuildID, add.ChannelID, func() *Context {
+		return mux.NewContextReaction(session, add.GuildID, add.ChannelID, add.MessageID, add.UserID, add)
+	})
 }
 
 // Event handler that fires when a reaction is removed
 func (mux *Multiplexer) onMessageReactionRemove(session *discordgo.Session, remove *discordgo.MessageReactionRemove) {
-	go func() {
-		for _, hook := range mux.MessageReactionRemove {
-			message, err := session.ChannelMessage(remove.ChannelID, remove.MessageID)
-			if err != nil {
-				log.Errorf("Error getting message %s from channel %s, %s", remove.MessageID, remove.ChannelID, err)
-				return
-			}
-			message.GuildID = remove.GuildID
-			context := mux.NewContextMessage(session, message, remove)
-			if context == nil {
-				return
-			}
-			hook(context)
-		}
-	}()
+	mux.emit(EventMessageReactionRemove, remove.GuildID, remove.ChannelID, func() *Context {
+		return mux.NewContextReaction(session, remove.GuildID, remove.ChannelID, remove.MessageID, remove.UserID, remove)
+	})
 }
 
 // Event handler that fires when voice state updates
 func (mux *Multiplexer) onVoiceStateUpdate(session *discordgo.Session, update *discordgo.VoiceStateUpdate) {
-	go func() {
-		for _, hook := range mux.VoiceStateUpdate {
-			var user *discordgo.User
-			member, err := session.State.Member(update.GuildID, update.UserID)
-			if err != nil {
-				member, err = session.GuildMember(update.GuildID, update.UserID)
-				if err != nil {
-					log.Errorf("Error getting member %s from guild %s, %s.", update.UserID, update.GuildID, err)
-				} else {
-					user = member.User
-				}
-			} else {
-				user = member.User
-			}
-			hook(&Context{
-				Multiplexer: mux,
-				User:        user,
-				Member:      member,
-				Session:     session,
-				Guild:       GetGuild(session, update.GuildID),
-				Channel:     GetChannel(session, update.ChannelID),
-				Event:       update,
-			})
-		}
-	}()
+	mux.emit(EventVoiceStateUpdate, update.GuildID, "", func() *Context {
+		context := mux.eventContext(session, update)
+		context.Member = GetMember(session, update.GuildID, update.UserID)
+		if context.Member != nil {
+			context.User = context.Member.User
+		}
+		context.Guild = GetGuild(session, update.GuildID)
+		context.Channel = GetChannel(session, update.ChannelID)
+		return context
+	})
+}
+
+// eventContext returns a Context of an event with no entity fields set.
+func (mux *Multiplexer) eventContext(session *discordgo.Session, event interface{}) *Context {
+	return &Context{
+		ctx:         mux.Context(),
+		Multiplexer: mux,
+		Session:     session,
+		Event:       event,
+	}
+}
+
+// place sets Guild, Channel and IsPrivate of an event context the way NewContextMessage does: Guild is empty
+// outside guilds and IsPrivate is set in direct messages. The guild is obtained by ID if not passed, false is
+// returned if it cannot be obtained so the event is dropped like member events.
+func (mux *Multiplexer) place(context *Context, guildID string, guild *discordgo.Guild, channel *discordgo.Channel) bool {
+	if guild == nil && guildID != "" {
+		if guild = GetGuild(context.Session, guildID); guild == nil {
+			return false
+		}
+	}
+	if guild == nil {
+		guild = &discordgo.Guild{}
+	}
+	context.Guild = guild
+	context.Channel = channel
+	context.IsPrivate = guildID == ""
+	if channel != nil {
+		context.IsPrivate = channel.Type == discordgo.ChannelTypeDM
+	}
+	return true
+}
+
+// emit dispatches an event to its hooks with a context made by build, skipping events no hook is registered to.
+// Events are dropped if build returns nil.
+func (mux *Multiplexer) emit(eventType EventType, guildID, channelID string, build func() *Context) {
+	if !mux.hooked(eventType) {
+		return
+	}
+	mux.dispatchOrdered(eventType, guildID, channelID, func() {
+		context := build()
+		if context == nil {
+			return
+		}
+		mux.runHooks(eventType, context)
+	})
+}
+
+// Event handler that fires when a channel is created
+func (mux *Multiplexer) onChannelCreate(session *discordgo.Session, create *discordgo.ChannelCreate) {
+	mux.emit(EventChannelCreate, create.GuildID, create.ID, func() *Context {
+		context := mux.eventContext(session, create)
+		if !mux.place(context, create.GuildID, nil, create.Channel) {
+			return nil
+		}
+		return context
+	})
+}
+
+// Event handler that fires when a channel is updated
+func (mux *Multiplexer) onChannelUpdate(session *discordgo.Session, update *discordgo.ChannelUpdate) {
+	mux.emit(EventChannelUpdate, update.GuildID, update.ID, func() *Context {
+		context := mux.eventContext(session, update)
+		if !mux.place(context, update.GuildID, nil, update.Channel) {
+			return nil
+		}
+		return context
+	})
+}
+
+// Event handler that fires when a channel is deleted
+func (mux *Multiplexer) onChannelDelete(session *discordgo.Session, delete *discordgo.ChannelDelete) {
+	mux.emit(EventChannelDelete, delete.GuildID, delete.ID, func() *Context {
+		context := mux.eventContext(session, delete)
+		if !mux.place(context, delete.GuildID, nil, delete.Channel) {
+			return nil
+		}
+		return context
+	})
+}
+
+// Event handler that fires when a guild becomes available or is joined
+func (mux *Multiplexer) onGuildCreate(session *discordgo.Session, create *discordgo.GuildCreate) {
+	mux.emit(EventGuildCreate, create.ID, "", func() *Context {
+		context := mux.eventContext(session, create)
+		mux.place(context, create.ID, create.Guild, nil)
+		return context
+	})
+}
+
+// Event handler that fires when a guild is updated
+func (mux *Multiplexer) onGuildUpdate(session *discordgo.Session, update *discordgo.GuildUpdate) {
+	mux.emit(EventGuildUpdate, update.ID, "", func() *Context {
+		context := mux.eventContext(session, update)
+		mux.place(context, update.ID, update.Guild, nil)
+		return context
+	})
+}
+
+// Event handler that fires when a role is created
+func (mux *Multiplexer) onGuildRoleCreate(session *discordgo.Session, create *discordgo.GuildRoleCreate) {
+	mux.emit(EventGuildRoleCreate, create.GuildID, "", func() *Context {
+		context := mux.eventContext(session, create)
+		if !mux.place(context, create.GuildID, nil, nil) {
+			return nil
+		}
+		return context
+	})
+}
+
+// Event handler that fires when a role is updated
+func (mux *Multiplexer) onGuildRoleUpdate(session *discordgo.Session, update *discordgo.GuildRoleUpdate) {
+	mux.emit(EventGuildRoleUpdate, update.GuildID, "", func() *Context {
+		context := mux.eventContext(session, update)
+		if !mux.place(context, update.GuildID, nil, nil) {
+			return nil
+		}
+		return context
+	})
+}
+
+// Event handler that fires when a role is deleted
+func (mux *Multiplexer) onGuildRoleDelete(session *discordgo.Session, delete *discordgo.GuildRoleDelete) {
+	mux.emit(EventGuildRoleDelete, delete.GuildID, "", func() *Context {
+		context := mux.eventContext(session, delete)
+		if !mux.place(context, delete.GuildID, nil, nil) {
+			return nil
+		}
+		return context
+	})
+}
+
+// Event handler that fires when a guild member is updated
+func (mux *Multiplexer) onGuildMemberUpdate(session *discordgo.Session, update *discordgo.GuildMemberUpdate) {
+	mux.emit(EventGuildMemberUpdate, update.GuildID, "", func() *Context {
+		context := mux.eventContext(session, update)
+		if !mux.place(context, update.GuildID, nil, nil) {
+			return nil
+		}
+		context.Member = update.Member
+		context.User = update.User
+		return context
+	})
+}
+
+// Event handler that fires when a user is banned
+func (mux *Multiplexer) onGuildBanAdd(session *discordgo.Session, add *discordgo.GuildBanAdd) {
+	mux.emit(EventGuildBanAdd, add.GuildID, "", func() *Context {
+		context := mux.eventContext(session, add)
+		if !mux.place(context, add.GuildID, nil, nil) {
+			return nil
+		}
+		context.User = add.User
+		return context
+	})
+}
+
+// Event handler that fires when a user is unbanned
+func (mux *Multiplexer) onGuildBanRemove(session *discordgo.Session, remove *discordgo.GuildBanRemove) {
+	mux.emit(EventGuildBanRemove, remove.GuildID, "", func() *Context {
+		context := mux.eventContext(session, remove)
+		if !mux.place(context, remove.GuildID, nil, nil) {
+			return nil
+		}
+		context.User = remove.User
+		return context
+	})
+}
+
+// Event handler that fires when a presence updates, User may only carry an ID
+func (mux *Multiplexer) onPresenceUpdate(session *discordgo.Session, update *discordgo.PresenceUpdate) {
+	mux.emit(EventPresenceUpdate, update.GuildID, "", func() *Context {
+		context := mux.eventContext(session, update)
+		if !mux.place(context, update.GuildID, nil, nil) {
+			return nil
+		}
+		context.User = update.User
+		if update.User != nil {
+			context.Member, _ = session.State.Member(update.GuildID, update.User.ID)
+		}
+		return context
+	})
+}
+
+// Event handler that fires when a user starts typing, User only carries an ID if the member is not cached
+func (mux *Multiplexer) onTypingStart(session *discordgo.Session, start *discordgo.TypingStart) {
+	mux.emit(EventTypingStart, start.GuildID, start.ChannelID, func() *Context {
+		context := mux.eventContext(session, start)
+		if !mux.place(context, start.GuildID, nil, GetChannel(session, start.ChannelID)) {
+			return nil
+		}
+		context.User = &discordgo.User{ID: start.UserID}
+		if member, err := session.State.Member(start.GuildID, start.UserID); err == nil {
+			context.Member = member
+			context.User = member.User
+		}
+		return context
+	})
+}
+
+// Event handler that fires when an invite is created
+func (mux *Multiplexer) onInviteCreate(session *discordgo.Session, create *discordgo.InviteCreate) {
+	mux.emit(EventInviteCreate, create.GuildID, create.ChannelID, func() *Context {
+		context := mux.eventContext(session, create)
+		if !mux.place(context, create.GuildID, nil, GetChannel(session, create.ChannelID)) {
+			return nil
+		}
+		if create.Invite != nil {
+			context.User = create.Inviter
+		}
+		return context
+	})
+}
+
+// Event handler that fires when an invite is deleted
+func (mux *Multiplexer) onInviteDelete(session *discordgo.Session, delete *discordgo.InviteDelete) {
+	mux.emit(EventInviteDelete, delete.GuildID, delete.ChannelID, func() *Context {
+		context := mux.eventContext(session, delete)
+		if !mux.place(context, delete.GuildID, nil, GetChannel(session, delete.ChannelID)) {
+			return nil
+		}
+		return context
+	})
+}
+
+// threadEvents maps gateway event names of threads to their event types.
+var threadEvents = map[string]EventType{
+	"THREAD_CREATE": EventThreadCreate,
+	"THREAD_UPDATE": EventThreadUpdate,
+	"THREAD_DELETE": EventThreadDelete,
+}
+
+// Event handler that fires on every gateway event, for events without a typed handler
+func (mux *Multiplexer) onEvent(session *discordgo.Session, event *discordgo.Event) {
+	eventType, ok := threadEvents[event.Type]
+	if !ok || !mux.hooked(eventType) {
+		return
+	}
+	var thread discordgo.Channel
+	if err := json.Unmarshal(event.RawData, &thread); err != nil {
+		mux.Logger().Errorf("Error decoding %s event, %s", event.Type, err)
+		return
+	}
+	mux.emit(eventType, thread.GuildID, thread.ID, func() *Context {
+		context := mux.eventContext(session, event)
+		if !mux.place(context, thread.GuildID, nil, &thread) {
+			return nil
+		}
+		return context
+	})
 }
@@ -0,0 +1,218 @@
+package multiplexer
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"sync"
+	"time"
+)
+
+// HistoryConfig configures the cache of recent messages passed to MessageDelete and MessageUpdate hooks.
+type HistoryConfig struct {
+	// Size is the amount of messages kept per channel.
+	Size int
+	// MaxAge is how long messages are kept, forever if zero.
+	MaxAge time.Duration
+	// MaxChannels is the amount of channels messages are kept of, DefaultHistoryChannels if zero.
+	// Messages of the channel least recently recorded in are dropped first.
+	MaxChannels int
+}
+
+// DefaultHistoryChannels is the amount of channels messages are kept of if not configured.
+const DefaultHistoryChannels = 1000
+
+// validate checks the configuration for invalid values.
+func (config HistoryConfig) validate() error {
+	if config.Size <= 0 {
+		return fmt.Errorf("%w: message history size must be positive", ErrInvalidOption)
+	}
+	if config.MaxAge < 0 {
+		return fmt.Errorf("%w: negative message history age", ErrInvalidOption)
+	}
+	if config.MaxChannels < 0 {
+		return fmt.Errorf("%w: negative message history channels", ErrInvalidOption)
+	}
+	return nil
+}
+
+// historySweepInterval is the amount of recorded messages between sweeps of idle channels.
+const historySweepInterval = 1024
+
+// historyEntry is a recorded message.
+type historyEntry struct {
+	message *discordgo.Message
+	added   time.Time
+}
+
+// historyRing is a ring buffer of recent messages of a channel, growing up to the history size.
+type historyRing struct {
+	channelID string
+	entries   []historyEntry
+	next      int
+	// element is the position of the ring in the recording order of channels.
+	element *list.Element
+}
+
+// find returns the index of a message in the ring, -1 if absent.
+func (ring *historyRing) find(messageID string) int {
+	for i, entry := range ring.entries {
+		if entry.message != nil && entry.message.ID == messageID {
+			return i
+		}
+	}
+	return -1
+}
+
+// messageHistory holds recent messages of each channel.
+type messageHistory struct {
+	mutex    sync.Mutex
+	config   HistoryConfig
+	channels map[string]*historyRing
+	// order holds rings by the time of their last recorded message, most recent first.
+	order    *list.List
+	recorded int
+}
+
+// newMessageHistory returns an empty history.
+func newMessageHistory(config HistoryConfig) *messageHistory {
+	if config.MaxChannels == 0 {
+		config.MaxChannels = DefaultHistoryChannels
+	}
+	return &messageHistory{
+		config:   config,
+		channels: make(map[string]*historyRing),
+		order:    list.New(),
+	}
+}
+
+// expired checks if an entry is older than the maximum age.
+func (history *messageHistory) expired(entry historyEntry, now time.Time) bool {
+	return history.config.MaxAge > 0 && now.Sub(entry.added) > history.config.MaxAge
+}
+
+// record adds a message, overwriting the oldest one of its channel if full.
+// The channel least recently recorded in is dropped if too many channels are kept.
+func (history *messageHistory) record(message *discordgo.Message) {
+	copied := *message
+	now := time.Now()
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	ring, ok := history.channels[message.ChannelID]
+	if ok {
+		history.order.MoveToFront(ring.element)
+	} else {
+		if len(history.channels) >= history.config.MaxChannels {
+			history.drop(history.order.Back().Value.(*historyRing))
+		}
+		ring = &historyRing{channelID: message.ChannelID}
+		ring.element = history.order.PushFront(ring)
+		history.channels[message.ChannelID] = ring
+	}
+	entry := historyEntry{message: &copied, added: now}
+	if len(ring.entries) < history.config.Size {
+		ring.entries = append(ring.entries, entry)
+	} else {
+		ring.entries[ring.next] = entry
+		ring.next = (ring.next + 1) % len(ring.entries)
+	}
+
+	history.recorded++
+	if history.recorded%historySweepInterval == 0 {
+		history.sweep(now)
+	}
+}
+
+// drop removes a channel, the mutex must be held.
+func (history *messageHistory) drop(ring *historyRing) {
+	history.order.Remove(ring.element)
+	delete(history.channels, ring.channelID)
+}
+
+// sweep removes channels whose messages all expired, the mutex must be held.
+func (history *messageHistory) sweep(now time.Time) {
+	if history.config.MaxAge == 0 {
+		return
+	}
+	for _, ring := range history.channels {
+		idle := true
+		for _, entry := range ring.entries {
+			if entry.message != nil && !history.expired(entry, now) {
+				idle = false
+				break
+			}
+		}
+		if idle {
+			history.drop(ring)
+		}
+	}
+}
+
+// lookup returns a recorded message, nil if absent or expired.
+func (history *messageHistory) lookup(channelID, messageID string) *discordgo.Message {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	ring, ok := history.channels[channelID]
+	if !ok {
+		return nil
+	}
+	i := ring.find(messageID)
+	if i < 0 || history.expired(ring.entries[i], time.Now()) {
+		return nil
+	}
+	return ring.entries[i].message
+}
+
+// update replaces a recorded message with its edited version and returns the previous one, nil if absent.
+// Partial updates without an author, such as embed resolution, only replace embeds.
+func (history *messageHistory) update(message *discordgo.Message) *discordgo.Message {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	ring, ok := history.channels[message.ChannelID]
+	if !ok {
+		return nil
+	}
+	i := ring.find(message.ID)
+	if i < 0 || history.expired(ring.entries[i], time.Now()) {
+		return nil
+	}
+	previous := ring.entries[i].message
+	var copied discordgo.Message
+	if message.Author == nil {
+		copied = *previous
+		copied.Embeds = message.Embeds
+	} else {
+		copied = *message
+	}
+	ring.entries[i].message = &copied
+	return previous
+}
+
+// remove removes a recorded message and returns it, nil if absent or expired.
+func (history *messageHistory) remove(channelID, messageID string) *discordgo.Message {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	ring, ok := history.channels[channelID]
+	if !ok {
+		return nil
+	}
+	i := ring.find(messageID)
+	if i < 0 {
+		return nil
+	}
+	entry := ring.entries[i]
+	ring.entries[i] = historyEntry{}
+	if history.expired(entry, time.Now()) {
+		return nil
+	}
+	return entry.message
+}
+
+// RecentMessage returns a message recorded by the message history, nil if not recorded or history is disabled.
+// The returned message must not be modified.
+func (mux *Multiplexer) RecentMessage(channelID, messageID string) *discordgo.Message {
+	if mux.history == nil {
+		return nil
+	}
+	return mux.history.lookup(channelID, messageID)
+}
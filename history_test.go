@@ -0,0 +1,37 @@
+package multiplexer
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"strconv"
+	"testing"
+)
+
+func TestMessageHistory(t *testing.T) {
+	history := newMessageHistory(HistoryConfig{Size: 2, MaxChannels: 2})
+	record := func(channelID string, id int) {
+		history.record(&discordgo.Message{ID: strconv.Itoa(id), ChannelID: channelID})
+	}
+
+	record("a", 1)
+	if length := len(history.channels["a"].entries); length != 1 {
+		t.Errorf("ring allocated %d entries for one message", length)
+	}
+	record("a", 2)
+	record("a", 3)
+	if history.lookup("a", "1") != nil || history.lookup("a", "3") == nil {
+		t.Error("oldest message of a full channel not overwritten")
+	}
+
+	record("b", 4)
+	record("a", 5)
+	record("c", 6)
+	if len(history.channels) != 2 || history.order.Len() != 2 {
+		t.Errorf("%d channels kept, want 2", len(history.channels))
+	}
+	if history.lookup("b", "4") != nil {
+		t.Error("least recently recorded channel not dropped")
+	}
+	if history.lookup("a", "5") == nil || history.lookup("c", "6") == nil {
+		t.Error("recently recorded channels dropped")
+	}
+}
@@ -0,0 +1,395 @@
+package multiplexer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHookPanicked represents the error reported when a hook panics.
+var ErrHookPanicked = errors.New("hook panicked")
+
+// BotFilter decides which authors of events a hook is called for.
+type BotFilter int
+
+// Bot filters.
+const (
+	// BotsAny calls the hook regardless of the author.
+	BotsAny BotFilter = iota
+	// BotsExcluded skips events of bot users.
+	BotsExcluded
+	// BotsOnly calls the hook only for events of bot users.
+	BotsOnly
+)
+
+// AuthorKind is a set of kinds of event authors skipped by HookIgnore.
+type AuthorKind int
+
+// Author kinds.
+const (
+	// AuthorSelf is the bot itself.
+	AuthorSelf AuthorKind = 1 << iota
+	// AuthorBot is any bot user, including the bot itself.
+	AuthorBot
+	// AuthorWebhook is a webhook posting a message.
+	AuthorWebhook
+	// AuthorSystem is Discord posting a system message, such as a join or pin notice.
+	AuthorSystem
+)
+
+// authorKinds returns the kinds of the author of the context's event.
+// The author of reaction events is the reacting user, not the author of the message.
+func (context *Context) authorKinds() AuthorKind {
+	author, message := context.User, context.Message
+	if context.actor != nil {
+		author, message = context.actor, nil
+	}
+	var kinds AuthorKind
+	if author != nil {
+		if author.Bot {
+			kinds |= AuthorBot
+		}
+		if context.Session != nil && context.Session.State != nil && context.Session.State.User != nil &&
+			author.ID == context.Session.State.User.ID {
+			kinds |= AuthorSelf | AuthorBot
+		}
+	}
+	if message != nil {
+		if message.WebhookID != "" {
+			kinds |= AuthorWebhook
+		}
+		if message.Type != discordgo.MessageTypeDefault && message.Type != discordgo.MessageTypeReply {
+			kinds |= AuthorSystem
+		}
+	}
+	return kinds
+}
+
+// registeredHook is a hook registered with On.
+type registeredHook struct {
+	id       uint64
+	function func(context *Context) error
+	name     string
+	priority int
+	guilds   *IDSet
+	channels *IDSet
+	bots     BotFilter
+	ignore   AuthorKind
+	lazy     bool
+	parallel bool
+	self     bool
+
+	calls  uint64
+	errors uint64
+	total  int64
+	max    int64
+}
+
+// HookStats holds timing metrics of a hook.
+type HookStats struct {
+	Calls  uint64
+	Errors uint64
+	// Total is the time spent in all calls.
+	Total time.Duration
+	// Max is the longest call.
+	Max time.Duration
+}
+
+// observe records a call of the hook.
+func (hook *registeredHook) observe(elapsed time.Duration, err error) {
+	atomic.AddUint64(&hook.calls, 1)
+	if err != nil {
+		atomic.AddUint64(&hook.errors, 1)
+	}
+	atomic.AddInt64(&hook.total, int64(elapsed))
+	for {
+		longest := atomic.LoadInt64(&hook.max)
+		if int64(elapsed) <= longest || atomic.CompareAndSwapInt64(&hook.max, longest, int64(elapsed)) {
+			return
+		}
+	}
+}
+
+// HookOption configures a hook registered with On.
+type HookOption func(*registeredHook)
+
+// HookName names the hook in logs.
+func HookName(name string) HookOption {
+	return func(hook *registeredHook) {
+		hook.name = name
+	}
+}
+
+// HookPriority sets the priority of the hook, hooks of higher priority are called first.
+// Hooks of equal priority are called in registration order.
+func HookPriority(priority int) HookOption {
+	return func(hook *registeredHook) {
+		hook.priority = priority
+	}
+}
+
+// HookGuilds restricts the hook to events of guilds.
+func HookGuilds(guildIDs ...string) HookOption {
+	return func(hook *registeredHook) {
+		hook.guilds = NewIDSet(guildIDs...)
+	}
+}
+
+// HookChannels restricts the hook to events of channels.
+func HookChannels(channelIDs ...string) HookOption {
+	return func(hook *registeredHook) {
+		hook.channels = NewIDSet(channelIDs...)
+	}
+}
+
+// HookBots sets which authors the hook is called for.
+func HookBots(filter BotFilter) HookOption {
+	return func(hook *registeredHook) {
+		hook.bots = filter
+	}
+}
+
+// HookIgnore skips events authored by any of kinds, such as AuthorSelf|AuthorWebhook.
+// The author of reaction events is the reacting user.
+func HookIgnore(kinds AuthorKind) HookOption {
+	return func(hook *registeredHook) {
+		hook.ignore |= kinds
+	}
+}
+
+// HookSelf calls a MessageCreate hook for the bot's own messages, which are skipped by default.
+func HookSelf() HookOption {
+	return func(hook *registeredHook) {
+		hook.self = true
+	}
+}
+
+// HookLazyMessage calls the hook on reaction events without fetching the message first.
+// Message only carries IDs until the hook calls ResolveMessage.
+func HookLazyMessage() HookOption {
+	return func(hook *registeredHook) {
+		hook.lazy = true
+	}
+}
+
+// HookParallel runs the hook concurrently with other hooks of the event instead of after earlier ones.
+// The event completes once all hooks returned. Parallel hooks get a copy of the context, so changes
+// they make to its fields are not seen by other hooks.
+func HookParallel() HookOption {
+	return func(hook *registeredHook) {
+		hook.parallel = true
+	}
+}
+
+// call calls the function of the hook, returning a panic as ErrHookPanicked.
+func (hook *registeredHook) call(context *Context) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("%w: %v", ErrHookPanicked, recovered)
+		}
+	}()
+	return hook.function(context)
+}
+
+// String returns the name of the hook, or its registration ID if unnamed.
+func (hook *registeredHook) String() string {
+	if hook.name != "" {
+		return hook.name
+	}
+	return "#" + strconv.FormatUint(hook.id, 10)
+}
+
+// acceptsLocation checks if the guild and channel filters of the hook accept a context.
+func (hook *registeredHook) acceptsLocation(context *Context) bool {
+	if hook.guilds != nil && !hook.guilds.Has(context.guildID()) {
+		return false
+	}
+	return hook.channels == nil || hook.channels.Has(context.channelID())
+}
+
+// acceptsAuthor checks if the author filters of the hook accept a context.
+func (hook *registeredHook) acceptsAuthor(context *Context) bool {
+	kinds := context.authorKinds()
+	if hook.ignore&kinds != 0 {
+		return false
+	}
+	bot := kinds&AuthorBot != 0
+	switch hook.bots {
+	case BotsExcluded:
+		return !bot
+	case BotsOnly:
+		return bot
+	}
+	return true
+}
+
+// hookRegistry holds hooks of each event type.
+// Slices are replaced instead of modified so events in flight keep iterating a consistent snapshot.
+type hookRegistry struct {
+	mutex  sync.RWMutex
+	hooks  map[EventType][]*registeredHook
+	nextID uint64
+}
+
+// add registers a hook in priority order.
+func (registry *hookRegistry) add(eventType EventType, hook *registeredHook) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.hooks == nil {
+		registry.hooks = make(map[EventType][]*registeredHook)
+	}
+	registry.nextID++
+	hook.id = registry.nextID
+	current := registry.hooks[eventType]
+	index := sort.Search(len(current), func(i int) bool {
+		return current[i].priority < hook.priority
+	})
+	hooks := make([]*registeredHook, 0, len(current)+1)
+	hooks = append(hooks, current[:index]...)
+	hooks = append(hooks, hook)
+	hooks = append(hooks, current[index:]...)
+	registry.hooks[eventType] = hooks
+}
+
+// remove unregisters a hook and returns whether it was registered.
+func (registry *hookRegistry) remove(eventType EventType, id uint64) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	current := registry.hooks[eventType]
+	for i, hook := range current {
+		if hook.id == id {
+			hooks := make([]*registeredHook, 0, len(current)-1)
+			hooks = append(hooks, current[:i]...)
+			hooks = append(hooks, current[i+1:]...)
+			registry.hooks[eventType] = hooks
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot returns hooks of an event type, the slice must not be modified.
+func (registry *hookRegistry) snapshot(eventType EventType) []*registeredHook {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	return registry.hooks[eventType]
+}
+
+// HookHandle is a hook registered with On.
+type HookHandle struct {
+	mux       *Multiplexer
+	eventType EventType
+	hook      *registeredHook
+}
+
+// Remove unregisters the hook and returns whether it was still registered.
+// Events already being processed may still call it.
+func (handle *HookHandle) Remove() bool {
+	return handle.mux.hooks.remove(handle.eventType, handle.hook.id)
+}
+
+// Name returns the name of the hook, or its registration ID if unnamed.
+func (handle *HookHandle) Name() string {
+	return handle.hook.String()
+}
+
+// Stats returns timing metrics of the hook.
+func (handle *HookHandle) Stats() HookStats {
+	return HookStats{
+		Calls:  atomic.LoadUint64(&handle.hook.calls),
+		Errors: atomic.LoadUint64(&handle.hook.errors),
+		Total:  time.Duration(atomic.LoadInt64(&handle.hook.total)),
+		Max:    time.Duration(atomic.LoadInt64(&handle.hook.max)),
+	}
+}
+
+// EventType returns the event type the hook is registered to.
+func (handle *HookHandle) EventType() EventType {
+	return handle.eventType
+}
+
+// On registers a hook called with the Context of events of a type and returns a handle to remove it.
+// It is safe to call at any time, including while events are processed.
+// MessageCreate hooks skip the bot's own messages unless HookSelf is passed.
+func (mux *Multiplexer) On(eventType EventType, function func(context *Context), options ...HookOption) *HookHandle {
+	return mux.OnErr(eventType, func(context *Context) error {
+		function(context)
+		return nil
+	}, options...)
+}
+
+// OnErr registers a hook like On whose returned errors are passed to the error reporter.
+func (mux *Multiplexer) OnErr(eventType EventType, function func(context *Context) error, options ...HookOption) *HookHandle {
+	hook := &registeredHook{function: function}
+	for _, option := range options {
+		option(hook)
+	}
+	if eventType == EventMessageCreate && !hook.self {
+		hook.ignore |= AuthorSelf
+	}
+	mux.hooks.add(eventType, hook)
+	return &HookHandle{mux: mux, eventType: eventType, hook: hook}
+}
+
+// hooked checks if any hook is registered to an event type.
+func (mux *Multiplexer) hooked(eventType EventType) bool {
+	return len(mux.hooks.snapshot(eventType)) > 0
+}
+
+// runHooks calls hooks of an event type with a context and waits for parallel ones to return.
+// Filters of all hooks are evaluated first, so messages of reaction events are resolved before any hook runs concurrently.
+func (mux *Multiplexer) runHooks(eventType EventType, context *Context) {
+	context.eventType = eventType
+	var accepted []*registeredHook
+	for _, hook := range mux.hooks.snapshot(eventType) {
+		if mux.hookAccepts(hook, context) {
+			accepted = append(accepted, hook)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, hook := range accepted {
+		if hook.parallel {
+			wg.Add(1)
+			copied := *context
+			go func(hook *registeredHook) {
+				defer wg.Done()
+				mux.callHook(eventType, hook, &copied)
+			}(hook)
+			continue
+		}
+		mux.callHook(eventType, hook, context)
+	}
+	wg.Wait()
+}
+
+// hookAccepts checks if a hook is called with a context, unless its filters or the blocklist skip it.
+// Location filters are checked first so skipped hooks do not fetch the message of reaction events.
+func (mux *Multiplexer) hookAccepts(hook *registeredHook, context *Context) bool {
+	if !hook.acceptsLocation(context) {
+		return false
+	}
+	if !hook.lazy && !context.ResolveMessage() {
+		return false
+	}
+	return hook.acceptsAuthor(context) && !mux.hookBlocked(context)
+}
+
+// callHook calls a hook, recording its duration and reporting its error or panic.
+func (mux *Multiplexer) callHook(eventType EventType, hook *registeredHook, context *Context) {
+	start := time.Now()
+	err := hook.call(context)
+	elapsed := time.Since(start)
+	hook.observe(elapsed, err)
+	if mux.slowHook > 0 && elapsed > mux.slowHook {
+		mux.Logger().Warnf("%s hook %s took %s", eventType, hook, elapsed)
+	}
+	if err != nil {
+		mux.reportError(context, ErrorReport{Err: err, EventType: eventType, Hook: hook.String()})
+	}
+}
@@ -0,0 +1,83 @@
+package multiplexer
+
+import (
+	"errors"
+	"github.com/bwmarrin/discordgo"
+	"sync"
+	"testing"
+)
+
+func TestRunHooks(t *testing.T) {
+	var mutex sync.Mutex
+	var reports []ErrorReport
+	mux, err := New(WithErrorReporter(func(context *Context, report ErrorReport) {
+		mutex.Lock()
+		reports = append(reports, report)
+		mutex.Unlock()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(chan string, 2)
+	mux.On(EventMessageCreate, func(context *Context) {
+		seen <- context.Text
+		context.Text = "parallel"
+	}, HookParallel(), HookPriority(2))
+	mux.On(EventMessageCreate, func(context *Context) {
+		context.Text = "sequential"
+	}, HookPriority(1))
+	mux.On(EventMessageCreate, func(context *Context) {
+		seen <- context.Text
+		panic("hook failure")
+	}, HookName("panicking"))
+
+	context := mux.eventContext(nil, nil)
+	context.Text = "original"
+	mux.runHooks(EventMessageCreate, context)
+	close(seen)
+
+	texts := make(map[string]bool)
+	for text := range seen {
+		texts[text] = true
+	}
+	if !texts["original"] || !texts["sequential"] {
+		t.Errorf("hooks saw %v", texts)
+	}
+	if context.Text != "sequential" {
+		t.Errorf("parallel hook changed the shared context to %q", context.Text)
+	}
+	if len(reports) != 1 || reports[0].Hook != "panicking" || !errors.Is(reports[0].Err, ErrHookPanicked) {
+		t.Errorf("panic not reported, %+v", reports)
+	}
+
+	if _, err := New(WithErrorReporter(nil)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("nil error reporter accepted, %v", err)
+	}
+}
+
+func TestHookSelf(t *testing.T) {
+	mux, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var called []string
+	mux.On(EventMessageCreate, func(context *Context) {
+		called = append(called, "default")
+	})
+	mux.On(EventMessageCreate, func(context *Context) {
+		called = append(called, "self")
+	}, HookSelf())
+
+	session := &discordgo.Session{State: discordgo.NewState()}
+	session.State.User = &discordgo.User{ID: "bot"}
+	context := mux.eventContext(session, nil)
+	context.User = session.State.User
+	mux.runHooks(EventMessageCreate, context)
+	if len(called) != 1 || called[0] != "self" {
+		t.Errorf("hooks called for own message, %v", called)
+	}
+	if context.Actor() != context.User {
+		t.Error("actor of a message event is not its author")
+	}
+}
@@ -0,0 +1,116 @@
+package multiplexer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// contextKey is the type of keys of values set by the multiplexer.
+type contextKey int
+
+const (
+	invocationIDKey contextKey = iota
+	traceIDKey
+)
+
+// InvocationID returns the ID of the invocation carried by ctx, empty if none.
+func InvocationID(ctx context.Context) string {
+	id, _ := ctx.Value(invocationIDKey).(string)
+	return id
+}
+
+// TraceID returns the trace ID carried by ctx, or the invocation ID if no trace ID was set.
+func TraceID(ctx context.Context) string {
+	if id, ok := ctx.Value(traceIDKey).(string); ok {
+		return id
+	}
+	return InvocationID(ctx)
+}
+
+// WithTraceID returns a copy of ctx carrying a trace ID.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey, id)
+}
+
+// newInvocationID returns a random invocation ID.
+func newInvocationID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// invocationContext returns a context for an invocation derived from the lifecycle context,
+// with a deadline if timeout is positive.
+func (mux *Multiplexer) invocationContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(mux.Context(), invocationIDKey, newInvocationID())
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// routeTimeout returns the timeout of a route, falling back to the default command timeout.
+func (mux *Multiplexer) routeTimeout(route *Route) time.Duration {
+	if route.Timeout != 0 {
+		return route.Timeout
+	}
+	return mux.commandTimeout
+}
+
+// deadlineExceeded checks if ctx was cancelled by its deadline.
+func deadlineExceeded(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// withValue wraps context.WithValue for methods of Context, whose receiver shadows the package.
+func withValue(ctx context.Context, key, value interface{}) context.Context {
+	return context.WithValue(ctx, key, value)
+}
+
+// InvocationID returns the ID of the invocation handled by the context.
+func (context *Context) InvocationID() string {
+	return InvocationID(context.base())
+}
+
+// TraceID returns the trace ID of the context, the invocation ID if none was set.
+func (context *Context) TraceID() string {
+	return TraceID(context.base())
+}
+
+// SetTraceID sets the trace ID carried by the context.
+func (context *Context) SetTraceID(id string) {
+	context.ctx = WithTraceID(context.base(), id)
+}
+
+// SetValue sets a request-scoped value carried by the context, keys follow the rules of context.WithValue.
+func (context *Context) SetValue(key, value interface{}) {
+	context.ctx = withValue(context.base(), key, value)
+}
+
+// runHandler calls the route's handler, replying with CommandTimeout if its deadline passes first.
+func (mux *Multiplexer) runHandler(route *Route, context *Context) {
+	// The handler may replace the context through SetValue, watch the one it started with
+	ctx := context.base()
+	invocationID := InvocationID(ctx)
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			select {
+			case <-finished:
+				return
+			default:
+			}
+			if deadlineExceeded(ctx) {
+				mux.Logger().Warnf("Route %s timed out in invocation %s", route.Pattern, invocationID)
+				context.SendMessage(CommandTimeout)
+			}
+		}
+	}()
+	route.Handler(context)
+	close(finished)
+}
@@ -0,0 +1,99 @@
+package multiplexer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrShuttingDown represents the error returned when the multiplexer is shutting down.
+var ErrShuttingDown = errors.New("multiplexer shutting down")
+
+// Context returns the lifecycle context of the multiplexer, cancelled when Shutdown gives up waiting or completes.
+func (mux *Multiplexer) Context() context.Context {
+	if mux.lifecycle == nil {
+		return context.Background()
+	}
+	return mux.lifecycle
+}
+
+// Stopping returns whether Shutdown has been called.
+func (mux *Multiplexer) Stopping() bool {
+	return atomic.LoadInt32(&mux.stopping) == 1
+}
+
+// track registers an in-flight task and returns false if the multiplexer is shutting down.
+func (mux *Multiplexer) track() bool {
+	mux.lifecycleMutex.Lock()
+	defer mux.lifecycleMutex.Unlock()
+	if mux.Stopping() {
+		return false
+	}
+	mux.inflight.Add(1)
+	return true
+}
+
+// tracked returns a task marking itself done after running.
+func (mux *Multiplexer) tracked(task func()) func() {
+	return func() {
+		defer mux.inflight.Done()
+		task()
+	}
+}
+
+// release cancels the lifecycle context, stops worker pools and stops watching the settings store.
+func (mux *Multiplexer) release() {
+	if mux.cancelLifecycle != nil {
+		mux.cancelLifecycle()
+	}
+	if mux.dispatcher != nil {
+		mux.dispatcher.stop()
+	}
+	if mux.settings != nil {
+		mux.settings.Close()
+	}
+}
+
+// Shutdown stops accepting events, removes handlers registered by SessionRegisterHandlers, cancels pending
+// awaits, closes open menus and waits for in-flight command handlers and hooks to return.
+//
+// If ctx is done before they return, the lifecycle context exposed on Context is cancelled so
+// long-running handlers can stop, and the error of ctx is returned.
+// The lifecycle context is cancelled, worker pools are stopped and Settings are closed once Shutdown
+// returns in any case.
+// A multiplexer cannot be restarted.
+func (mux *Multiplexer) Shutdown(ctx context.Context) error {
+	mux.lifecycleMutex.Lock()
+	if mux.Stopping() {
+		mux.lifecycleMutex.Unlock()
+		return nil
+	}
+	atomic.StoreInt32(&mux.stopping, 1)
+	removers := mux.removers
+	mux.removers = nil
+	mux.lifecycleMutex.Unlock()
+
+	for _, remove := range removers {
+		remove()
+	}
+	mux.waiters.stop()
+	for _, menu := range mux.menus.open() {
+		menu.Close()
+	}
+	mux.Logger().Infof("Shutting down, waiting for in-flight handlers")
+
+	done := make(chan struct{})
+	go func() {
+		mux.inflight.Wait()
+		close(done)
+	}()
+	defer mux.release()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		mux.Logger().Warnf("Shutdown deadline exceeded, cancelling in-flight handlers")
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,44 @@
+package multiplexer
+
+import (
+	"git.randomchars.net/freenitori/log"
+	"github.com/sirupsen/logrus"
+)
+
+// Logger is the logging interface used by a Multiplexer, satisfied by *logrus.Logger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// defaultLogger logs through the package-level logger.
+type defaultLogger struct{}
+
+func (defaultLogger) Debugf(format string, args ...interface{}) { log.Debugf(format, args...) }
+func (defaultLogger) Infof(format string, args ...interface{})  { log.Infof(format, args...) }
+func (defaultLogger) Warnf(format string, args ...interface{})  { log.Warnf(format, args...) }
+func (defaultLogger) Errorf(format string, args ...interface{}) { log.Errorf(format, args...) }
+
+// levelLogger is implemented by loggers reporting their level, such as *logrus.Logger.
+type levelLogger interface {
+	IsLevelEnabled(level logrus.Level) bool
+}
+
+func (defaultLogger) IsLevelEnabled(level logrus.Level) bool { return log.GetLevel() >= level }
+
+// debugEnabled checks if the logger of the multiplexer logs debug messages,
+// false for loggers not reporting their level.
+func (mux *Multiplexer) debugEnabled() bool {
+	logger, ok := mux.Logger().(levelLogger)
+	return ok && logger.IsLevelEnabled(logrus.DebugLevel)
+}
+
+// Logger returns the logger of the multiplexer.
+func (mux *Multiplexer) Logger() Logger {
+	if mux.logger == nil {
+		return defaultLogger{}
+	}
+	return mux.logger
+}
@@ -0,0 +1,257 @@
+package multiplexer
+
+import (
+	"errors"
+	"github.com/bwmarrin/discordgo"
+	"sync"
+	"time"
+)
+
+// ErrMessageNotSent represents the error returned when a message could not be sent.
+var ErrMessageNotSent = errors.New("message not sent")
+
+// ErrMenuExists represents the error returned when a message already has a menu.
+var ErrMenuExists = errors.New("message already has a menu")
+
+// DefaultMenuTimeout is how long menus stay open without a button being pressed if no timeout is set.
+const DefaultMenuTimeout = 2 * time.Minute
+
+// MenuButton is a reaction button of a menu.
+type MenuButton struct {
+	// Emoji is a unicode emoji, or name:id of a custom emoji.
+	Emoji string
+	// Handler is called when an allowed user presses the button, its error is passed to the error reporter.
+	// User of the context is the user pressing the button and Message is the message of the menu.
+	// Handlers of a menu are called one at a time.
+	Handler func(context *Context, menu *Menu) error
+}
+
+// MenuOptions configures a menu.
+type MenuOptions struct {
+	// Users are IDs of users allowed to press buttons, anyone if empty.
+	Users []string
+	// Timeout closes the menu once no button has been pressed for this long, DefaultMenuTimeout if zero.
+	Timeout time.Duration
+	// KeepReactions keeps reactions on the message once the menu closes.
+	KeepReactions bool
+	// OnClose is called once the menu closes.
+	OnClose func(menu *Menu)
+}
+
+// Menu is a message with reaction buttons bound to it.
+type Menu struct {
+	// Message is the message of the menu.
+	Message *discordgo.Message
+
+	mux      *Multiplexer
+	session  *discordgo.Session
+	buttons  []MenuButton
+	options  MenuOptions
+	users    *IDSet
+	handling sync.Mutex
+	once     sync.Once
+	done     chan struct{}
+
+	// mutex guards the timer, which is not reset once the menu closes.
+	mutex  sync.Mutex
+	timer  *time.Timer
+	closed bool
+}
+
+// menuRegistry routes reactions to menus by message ID.
+type menuRegistry struct {
+	mutex sync.RWMutex
+	menus map[string]*Menu
+}
+
+// add registers a menu unless its message already has one.
+func (registry *menuRegistry) add(menu *Menu) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.menus == nil {
+		registry.menus = make(map[string]*Menu)
+	}
+	if _, ok := registry.menus[menu.Message.ID]; ok {
+		return false
+	}
+	registry.menus[menu.Message.ID] = menu
+	return true
+}
+
+// remove unregisters the menu of a message.
+func (registry *menuRegistry) remove(messageID string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	delete(registry.menus, messageID)
+}
+
+// open returns open menus.
+func (registry *menuRegistry) open() []*Menu {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	menus := make([]*Menu, 0, len(registry.menus))
+	for _, menu := range registry.menus {
+		menus = append(menus, menu)
+	}
+	return menus
+}
+
+// get returns the menu of a message, nil if none.
+func (registry *menuRegistry) get(messageID string) *Menu {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	return registry.menus[messageID]
+}
+
+// NewMenu binds buttons to a sent message and adds their reactions.
+// The menu closes on timeout, when Close is called, or when the multiplexer shuts down.
+func (mux *Multiplexer) NewMenu(session *discordgo.Session, message *discordgo.Message, buttons []MenuButton, options MenuOptions) (*Menu, error) {
+	if options.Timeout == 0 {
+		options.Timeout = DefaultMenuTimeout
+	}
+	menu := &Menu{
+		Message: message,
+		mux:     mux,
+		session: session,
+		buttons: buttons,
+		options: options,
+		done:    make(chan struct{}),
+	}
+	if len(options.Users) > 0 {
+		menu.users = NewIDSet(options.Users...)
+	}
+	menu.timer = time.NewTimer(options.Timeout)
+	if !mux.menus.add(menu) {
+		menu.timer.Stop()
+		return nil, ErrMenuExists
+	}
+	// Menus registered before Shutdown are closed by it, later ones are refused here
+	if !mux.track() {
+		menu.Close()
+		return nil, ErrShuttingDown
+	}
+	go mux.tracked(menu.watch)()
+
+	for _, button := range buttons {
+		if err := session.MessageReactionAdd(message.ChannelID, message.ID, button.Emoji); err != nil {
+			menu.Close()
+			return nil, err
+		}
+	}
+	return menu, nil
+}
+
+// SendMenu sends a text message in the current channel with reaction buttons bound to it.
+func (context *Context) SendMenu(message string, buttons []MenuButton, options MenuOptions) (*Menu, error) {
+	sent := context.SendMessage(message)
+	if sent == nil {
+		return nil, ErrMessageNotSent
+	}
+	return context.Multiplexer.NewMenu(context.Session, sent, buttons, options)
+}
+
+// Done returns a channel closed once the menu closes.
+func (menu *Menu) Done() <-chan struct{} {
+	return menu.done
+}
+
+// watch closes the menu on timeout or when the multiplexer shuts down.
+func (menu *Menu) watch() {
+	select {
+	case <-menu.done:
+	case <-menu.timer.C:
+		menu.Close()
+	case <-menu.mux.Context().Done():
+		menu.Close()
+	}
+}
+
+// Close closes the menu and removes its reactions unless KeepReactions is set, it is safe to call more than once.
+func (menu *Menu) Close() {
+	menu.once.Do(func() {
+		menu.mux.menus.remove(menu.Message.ID)
+		menu.mutex.Lock()
+		menu.closed = true
+		menu.timer.Stop()
+		menu.mutex.Unlock()
+		close(menu.done)
+		if !menu.options.KeepReactions {
+			menu.removeReactions()
+		}
+		if menu.options.OnClose != nil {
+			menu.options.OnClose(menu)
+		}
+	})
+}
+
+// removeReactions removes all reactions of the message, or only the bot's own without permission to.
+func (menu *Menu) removeReactions() {
+	if menu.session.MessageReactionsRemoveAll(menu.Message.ChannelID, menu.Message.ID) == nil {
+		return
+	}
+	for _, button := range menu.buttons {
+		_ = menu.session.MessageReactionRemove(menu.Message.ChannelID, menu.Message.ID, button.Emoji, "@me")
+	}
+}
+
+// button returns the button of an emoji, nil if none.
+func (menu *Menu) button(emoji string) *MenuButton {
+	for i := range menu.buttons {
+		if menu.buttons[i].Emoji == emoji {
+			return &menu.buttons[i]
+		}
+	}
+	return nil
+}
+
+// press calls the handler of a button pressed by a user and removes the user's reaction so it can be pressed again.
+func (menu *Menu) press(context *Context, button *MenuButton, userID string) {
+	menu.handling.Lock()
+	defer menu.handling.Unlock()
+	select {
+	case <-menu.done:
+		return
+	default:
+	}
+	menu.mutex.Lock()
+	// A timer already fired closes the menu regardless of the press
+	if !menu.closed && menu.timer.Stop() {
+		menu.timer.Reset(menu.options.Timeout)
+	}
+	menu.mutex.Unlock()
+	_ = menu.session.MessageReactionRemove(menu.Message.ChannelID, menu.Message.ID, button.Emoji, userID)
+	if err := button.Handler(context, menu); err != nil {
+		menu.mux.reportError(context, ErrorReport{Err: err, EventType: EventMessageReactionAdd, Hook: "menu " + button.Emoji})
+	}
+}
+
+// routeMenu routes a reaction to the menu of its message, skipping the bot's own reactions and users not allowed to press.
+func (mux *Multiplexer) routeMenu(session *discordgo.Session, reaction *discordgo.MessageReaction, event interface{}) {
+	menu := mux.menus.get(reaction.MessageID)
+	if menu == nil || reaction.UserID == session.State.User.ID {
+		return
+	}
+	if menu.users != nil && !menu.users.Has(reaction.UserID) {
+		return
+	}
+	if mux.Blocked(reaction.UserID, reaction.GuildID, reaction.ChannelID) {
+		return
+	}
+	button := menu.button(reaction.Emoji.APIName())
+	if button == nil || button.Handler == nil {
+		return
+	}
+	mux.dispatchOrdered(EventMessageReactionAdd, reaction.GuildID, reaction.ChannelID, func() {
+		context := mux.NewContextReaction(session, reaction.GuildID, reaction.ChannelID, reaction.MessageID, reaction.UserID, event)
+		// The message of the menu is known, so it is not fetched, and the user is the one pressing
+		context.lazy = nil
+		message := *menu.Message
+		message.GuildID = reaction.GuildID
+		mux.setMessage(context, &message)
+		context.User, context.Member = context.actor, nil
+		if member, err := session.State.Member(reaction.GuildID, reaction.UserID); err == nil {
+			context.Member = member
+		}
+		menu.press(context, button, reaction.UserID)
+	})
+}
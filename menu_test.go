@@ -0,0 +1,34 @@
+package multiplexer
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"testing"
+	"time"
+)
+
+func TestShutdownClosesMenus(t *testing.T) {
+	mux, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &discordgo.Session{}
+	menu, err := mux.NewMenu(session, &discordgo.Message{ID: "menu"}, nil, MenuOptions{KeepReactions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = mux.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown waited for an open menu, %s", err)
+	}
+	select {
+	case <-menu.Done():
+	default:
+		t.Error("menu still open after shutdown")
+	}
+	if _, err = mux.NewMenu(session, &discordgo.Message{ID: "late"}, nil, MenuOptions{KeepReactions: true}); err != ErrShuttingDown {
+		t.Errorf("menu opened after shutdown, %v", err)
+	}
+}
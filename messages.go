@@ -0,0 +1,133 @@
+package multiplexer
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"sync"
+	"time"
+)
+
+// DefaultMessageCacheTTL is how long fetched messages are reused if no TTL is configured.
+const DefaultMessageCacheTTL = 10 * time.Second
+
+// maxFetchedMessages is the amount of cached messages above which expired ones are swept.
+const maxFetchedMessages = 4096
+
+// fetchedMessage is a message fetched or being fetched.
+type fetchedMessage struct {
+	done    chan struct{}
+	message *discordgo.Message
+	err     error
+	// expiry is zero while the message is being fetched.
+	expiry time.Time
+}
+
+// messageCache holds fetched messages for a short time to absorb bursts of events on the same message.
+// Cached messages do not reflect changes made within the TTL, such as reactions.
+type messageCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]*fetchedMessage
+}
+
+// fetch returns a message from the cache, state or API, sharing fetches of the same message in progress.
+func (cache *messageCache) fetch(session *discordgo.Session, guildID, channelID, messageID string) (*discordgo.Message, error) {
+	now := time.Now()
+	cache.mutex.Lock()
+	if entry, ok := cache.entries[messageID]; ok && (entry.expiry.IsZero() || now.Before(entry.expiry)) {
+		cache.mutex.Unlock()
+		<-entry.done
+		return entry.message, entry.err
+	}
+	if cache.entries == nil {
+		cache.entries = make(map[string]*fetchedMessage)
+	}
+	if len(cache.entries) >= maxFetchedMessages {
+		cache.sweep(now)
+	}
+	entry := &fetchedMessage{done: make(chan struct{})}
+	cache.entries[messageID] = entry
+	cache.mutex.Unlock()
+
+	entry.message, entry.err = fetchMessage(session, channelID, messageID)
+	if entry.err == nil {
+		entry.message.GuildID = guildID
+	}
+
+	cache.mutex.Lock()
+	if entry.err != nil || cache.ttl < 0 {
+		delete(cache.entries, messageID)
+	} else {
+		entry.expiry = time.Now().Add(cache.ttl)
+	}
+	cache.mutex.Unlock()
+	close(entry.done)
+	return entry.message, entry.err
+}
+
+// sweep removes expired messages, the mutex must be held.
+func (cache *messageCache) sweep(now time.Time) {
+	for id, entry := range cache.entries {
+		if !entry.expiry.IsZero() && !now.Before(entry.expiry) {
+			delete(cache.entries, id)
+		}
+	}
+}
+
+// fetchMessage fetches a message from state then API.
+func fetchMessage(session *discordgo.Session, channelID, messageID string) (*discordgo.Message, error) {
+	if message, err := session.State.Message(channelID, messageID); err == nil {
+		// Copy so the guild ID set by the cache does not race with state updates
+		copied := *message
+		return &copied, nil
+	}
+	return session.ChannelMessage(channelID, messageID)
+}
+
+// lazyMessage fetches the message of an event once, on first use, for the context and its copies.
+type lazyMessage struct {
+	once    sync.Once
+	fetch   func() (*discordgo.Message, error)
+	message *discordgo.Message
+}
+
+// NewContextReaction returns pointer to Context of a reaction event on a message, fetched when first needed.
+// Until then Message only carries IDs and User is nil, User is the author of the message once fetched.
+// Unlike NewContextMessage, reactions on the bot's own messages are not dropped.
+func (mux *Multiplexer) NewContextReaction(session *discordgo.Session, guildID, channelID, messageID, userID string, event interface{}) *Context {
+	context := mux.eventContext(session, event)
+	context.Message = &discordgo.Message{ID: messageID, ChannelID: channelID, GuildID: guildID}
+	context.Guild = GetGuild(session, guildID)
+	context.Channel = GetChannel(session, channelID)
+	context.actor = &discordgo.User{ID: userID}
+	if member, err := session.State.Member(guildID, userID); err == nil && member.User != nil {
+		context.actor = member.User
+	}
+	context.lazy = &lazyMessage{fetch: func() (*discordgo.Message, error) {
+		return mux.messages.fetch(session, guildID, channelID, messageID)
+	}}
+	return context
+}
+
+// ResolveMessage fetches the message of a reaction event if not fetched yet, setting Message and fields derived from it.
+// It returns false if the message could not be fetched, and true for events not fetching messages.
+func (context *Context) ResolveMessage() bool {
+	if context.lazy == nil {
+		return true
+	}
+	lazy := context.lazy
+	lazy.once.Do(func() {
+		message, err := lazy.fetch()
+		if err != nil {
+			context.Multiplexer.Logger().Errorf("Error getting message %s from channel %s, %s",
+				context.Message.ID, context.Message.ChannelID, err)
+			return
+		}
+		lazy.message = message
+	})
+	// Copies of the context given to parallel hooks each set the fetched message on themselves
+	if lazy.message == nil || !context.Multiplexer.setMessage(context, lazy.message) {
+		return false
+	}
+	context.lazy = nil
+	return true
+}
@@ -1,65 +1,193 @@
 package multiplexer
 
-import "github.com/bwmarrin/discordgo"
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 // Multiplexer represents the event router.
+// Configuration is set with options and setters refusing changes once SessionRegisterHandlers is called,
+// hooks are registered with On at any time.
 type Multiplexer struct {
-	// Prefix is the default command prefix.
-	Prefix string
+	// EventHandlers is a slice of event handler functions registered to the library directly,
+	// it must only be modified before SessionRegisterHandlers is called.
+	EventHandlers []interface{}
 
-	// Routes is a slice of pointers to command routes.
-	Routes []*Route
-	// Categories is a slice of pointers to CommandCategory.
-	Categories []*CommandCategory
+	prefix              string
+	routes              []*Route
+	categories          []*CommandCategory
+	administrators      IDSet
+	operators           IDSet
+	runtimeOperators    IDSet
+	operatorStore       OperatorStore
+	operatorMutex       sync.Mutex
+	applicationOwners   bool
+	ownersLoaded        int32
+	homeGuild           string
+	operatorRoles       IDSet
+	managerRoles        ManagerRoleStore
+	defaultManagerRoles memoryManagerRoles
+	features            FeatureStore
+	defaultFeatures     memoryFeatureStore
+	featureMutex        sync.Mutex
+	overrides           OverrideStore
+	defaultOverrides    memoryOverrideStore
+	overrideMutex       sync.Mutex
+	blocklist           blocklist
+	blocklistStore      BlocklistStore
+	blockHooks          bool
+	settings            *Settings
+	dispatcher          *dispatcher
+	hooks               hookRegistry
+	messages            messageCache
+	history             *messageHistory
+	split               SplitConfig
+	menus               menuRegistry
+	waiters             waiterRegistry
+	maxWaiters          int
+	confirmBypass       string
+	wizards             wizardRegistry
+	wizardStore         WizardStore
+	errorReporter       ErrorReporter
+	slowHook            time.Duration
+	commandTimeout      time.Duration
 
-	// EventHandlers is a slice of event handler functions registered to the library directly
-	EventHandlers []interface{}
+	lifecycle       context.Context
+	cancelLifecycle context.CancelFunc
+	lifecycleMutex  sync.Mutex
+	inflight        sync.WaitGroup
+	stopping        int32
+	removers        []func()
 
-	NotTargeted           []func(context *Context)
-	Ready                 []func(context *Context)
-	GuildMemberAdd        []func(context *Context)
-	GuildMemberRemove     []func(context *Context)
-	GuildDelete           []func(context *Context)
-	MessageCreate         []func(context *Context)
-	MessageDelete         []func(context *Context)
-	MessageUpdate         []func(context *Context)
-	MessageReactionAdd    []func(context *Context)
-	MessageReactionRemove []func(context *Context)
-	VoiceStateUpdate      []func(context *Context)
-
-	// Administrator is the privileged administrator user with all privilege overrides and full access to all commands.
-	Administrator *discordgo.User
-	// Operator is a slice of operator users with all privilege overrides and access to some restricted commands.
-	Operator []*discordgo.User
+	matcher     Matcher
+	policy      DispatchPolicy
+	logger      Logger
+	prefixStore PrefixStore
+	started     int32
 }
 
-// Route registers a route to the router.
+// Route registers a route to the router, registering a copy of its category if no category with its title is registered yet,
+// otherwise the route joins the registered category.
+// It panics with ErrAlreadyStarted if called after SessionRegisterHandlers.
 func (mux *Multiplexer) Route(route *Route) *Route {
-	route.Category.Routes = append(route.Category.Routes, route)
-	mux.Routes = append(mux.Routes, route)
+	if mux.Started() {
+		panic(ErrAlreadyStarted)
+	}
+	if route.Category != nil {
+		// Routes of a category with the title of a registered one join the registered one,
+		// others register a copy so categories shared between multiplexers are not modified
+		if registered := mux.Category(route.Category.Title); registered != nil {
+			route.Category = registered
+		} else {
+			route.Category = route.Category.copy()
+			mux.categories = append(mux.categories, route.Category)
+		}
+		route.Category.Routes = append(route.Category.Routes, route)
+	}
+	mux.routes = append(mux.routes, route)
 	return route
 }
 
-func (mux *Multiplexer) SessionRegisterHandlers(session *discordgo.Session) {
-	for _, handler := range mux.EventHandlers {
-		session.AddHandler(handler)
+// Routes returns registered routes in registration order.
+func (mux *Multiplexer) Routes() []*Route {
+	return append([]*Route(nil), mux.routes...)
+}
+
+// Categories returns registered categories in registration order.
+func (mux *Multiplexer) Categories() []*CommandCategory {
+	return append([]*CommandCategory(nil), mux.categories...)
+}
+
+// Prefix returns the default command prefix.
+func (mux *Multiplexer) Prefix() string {
+	return mux.prefix
+}
+
+// SetPrefix sets the default command prefix, it returns ErrAlreadyStarted after SessionRegisterHandlers.
+func (mux *Multiplexer) SetPrefix(prefix string) error {
+	mux.lifecycleMutex.Lock()
+	defer mux.lifecycleMutex.Unlock()
+	if mux.Started() {
+		return ErrAlreadyStarted
+	}
+	options := Options{Prefix: prefix, PrefixStore: mux.prefixStore, Policy: mux.policy}
+	if err := options.validate(); err != nil {
+		return err
 	}
+	mux.prefix = prefix
+	return nil
 }
 
-// IsOperator checks of a user is an operator.
-func (mux *Multiplexer) IsOperator(id string) bool {
-	if id == mux.Administrator.ID {
-		return true
+// Category returns a registered category by its title, case-insensitively.
+func (mux *Multiplexer) Category(title string) *CommandCategory {
+	for _, category := range mux.categories {
+		if strings.EqualFold(category.Title, title) {
+			return category
+		}
 	}
-	for _, operator := range mux.Operator {
-		if id == operator.ID {
-			return true
+	return nil
+}
+
+// VisibleCategories returns registered categories that are not hidden.
+func (mux *Multiplexer) VisibleCategories() []*CommandCategory {
+	var categories []*CommandCategory
+	for _, category := range mux.categories {
+		if !category.Hidden {
+			categories = append(categories, category)
 		}
 	}
-	return false
+	return categories
+}
+
+// SessionRegisterHandlers registers event handlers to a session, after which Route and RegisterCategory
+// refuse to register more.
+// It may be called once per session when sharding, and does nothing after Shutdown.
+func (mux *Multiplexer) SessionRegisterHandlers(session *discordgo.Session) {
+	mux.lifecycleMutex.Lock()
+	defer mux.lifecycleMutex.Unlock()
+	if mux.Stopping() {
+		return
+	}
+	atomic.StoreInt32(&mux.started, 1)
+	if mux.dispatcher != nil && mux.dispatcher.config.Ordering != OrderNone && !session.SyncEvents {
+		mux.Logger().Warnf("Event ordering is enabled without Session.SyncEvents, " +
+			"events are processed in the order handlers run instead of the order received")
+	}
+	for _, handler := range mux.EventHandlers {
+		mux.removers = append(mux.removers, session.AddHandler(handler))
+	}
+}
+
+// Started returns whether handlers have been registered to a session.
+func (mux *Multiplexer) Started() bool {
+	return atomic.LoadInt32(&mux.started) == 1
+}
+
+// Administrators returns the set of administrator IDs.
+func (mux *Multiplexer) Administrators() *IDSet {
+	return &mux.administrators
+}
+
+// Operators returns the set of operator IDs, administrators are not included.
+func (mux *Multiplexer) Operators() *IDSet {
+	return &mux.operators
+}
+
+// Settings returns Settings backed by the Store passed with WithStore, nil if none was passed.
+func (mux *Multiplexer) Settings() *Settings {
+	return mux.settings
+}
+
+// IsOperator checks of a user is an operator or an administrator.
+func (mux *Multiplexer) IsOperator(id string) bool {
+	return mux.IsAdministrator(id) || mux.operators.Has(id)
 }
 
-// IsAdministrator checks of a user is the system administrator.
+// IsAdministrator checks of a user is a system administrator.
 func (mux *Multiplexer) IsAdministrator(id string) bool {
-	return id == mux.Administrator.ID
+	return mux.administrators.Has(id)
 }
@@ -0,0 +1,28 @@
+package multiplexer
+
+import (
+	"errors"
+	"github.com/bwmarrin/discordgo"
+	"testing"
+)
+
+func TestSetPrefix(t *testing.T) {
+	mux, err := New(WithPrefix("!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mux.SetPrefix(" ?"); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("prefix beginning with whitespace accepted, %v", err)
+	}
+	if err = mux.SetPrefix("?"); err != nil || mux.Prefix() != "?" {
+		t.Errorf("prefix not set, %q %v", mux.Prefix(), err)
+	}
+
+	mux.SessionRegisterHandlers(&discordgo.Session{})
+	if err = mux.SetPrefix("."); err != ErrAlreadyStarted || mux.Prefix() != "?" {
+		t.Errorf("prefix changed after start, %q %v", mux.Prefix(), err)
+	}
+	if routes := mux.Routes(); len(routes) != len(mux.routes) {
+		t.Errorf("%d routes returned, want %d", len(routes), len(mux.routes))
+	}
+}
@@ -2,31 +2,24 @@ package multiplexer
 
 import (
 	"fmt"
-	"git.randomchars.net/freenitori/log"
 	"github.com/bwmarrin/discordgo"
 	"regexp"
 	"strings"
 )
 
-// New returns a command router.
-func New() *Multiplexer {
-	mux := &Multiplexer{
-		Categories: []*CommandCategory{AudioCategory, ExperienceCategory, ManualsCategory, MediaCategory, ModerationCategory, SystemCategory},
+// New returns a command router configured with opts.
+// DefaultCategories are registered unless WithCategories is passed, use NewWithOptions to register none.
+func New(opts ...Option) (*Multiplexer, error) {
+	var options Options
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return nil, err
+		}
 	}
-	mux.EventHandlers = []interface{}{
-		mux.handleMessageCommand,
-		mux.onReady,
-		mux.onGuildMemberAdd,
-		mux.onGuildMemberRemove,
-		mux.onGuildDelete,
-		mux.onMessageCreate,
-		mux.onMessageDelete,
-		mux.onMessageUpdate,
-		mux.onMessageReactionAdd,
-		mux.onMessageReactionRemove,
-		mux.onVoiceStateUpdate,
+	if options.Categories == nil {
+		options.Categories = DefaultCategories()
 	}
-	return mux
+	return NewWithOptions(options)
 }
 
 // NewCategory returns a new command category
@@ -38,11 +31,28 @@ func NewCategory(name string, description string) *CommandCategory {
 	return cat
 }
 
-// NewContextMessage returns pointer to Context generated from a message.
+// NewContextMessage returns pointer to Context generated from a message, nil for the bot's own messages.
+// Use NewContextReaction for reactions, which are not dropped on the bot's own messages.
 func (mux *Multiplexer) NewContextMessage(session *discordgo.Session, message *discordgo.Message, event interface{}) *Context {
 	if message.Author.ID == session.State.User.ID {
 		return nil
 	}
+	return mux.newContextMessage(session, message, event)
+}
+
+// newContextMessage returns pointer to Context generated from a message, including the bot's own.
+func (mux *Multiplexer) newContextMessage(session *discordgo.Session, message *discordgo.Message, event interface{}) *Context {
+	context := mux.eventContext(session, event)
+	if !mux.setMessage(context, message) {
+		return nil
+	}
+	return context
+}
+
+// setMessage sets fields of a context derived from a message.
+// It returns false if the channel of the message cannot be obtained.
+func (mux *Multiplexer) setMessage(context *Context, message *discordgo.Message) bool {
+	session := context.Session
 
 	guild := GetGuild(session, message.GuildID)
 	if guild == nil {
@@ -51,29 +61,23 @@ func (mux *Multiplexer) NewContextMessage(session *discordgo.Session, message *d
 
 	channel := GetChannel(session, message.ChannelID)
 	if channel == nil {
-		log.Errorf("Error obtaining channel when making Context.")
-		return nil
+		mux.Logger().Errorf("Error obtaining channel when making Context.")
+		return false
 	}
 
-	context := &Context{
-		Multiplexer: mux,
-		User:        message.Author,
-		Message:     message,
-		Session:     session,
-		Guild:       guild,
-		Channel:     channel,
-		Event:       event,
-		Text:        strings.TrimSpace(message.Content),
-		Fields:      nil,
-		IsPrivate:   channel.Type == discordgo.ChannelTypeDM,
-	}
+	context.User = message.Author
+	context.Message = message
+	context.Guild = guild
+	context.Channel = channel
+	context.Text = strings.TrimSpace(message.Content)
+	context.IsPrivate = channel.Type == discordgo.ChannelTypeDM
 
 	// Get guild-specific prefix
 	guildPrefix := context.Prefix()
 
 	// Look for ping
 	for _, mentionedUser := range message.Mentions {
-		if mentionedUser.ID == session.State.User.ID {
+		if mentionedUser.ID == session.State.User.ID && !mux.policy.IgnoreMentions {
 			context.IsTargeted, context.HasMention = true, true
 			mentionRegex := regexp.MustCompile(fmt.Sprintf("<@!?(%s)>", session.State.User.ID))
 
@@ -103,5 +107,5 @@ func (mux *Multiplexer) NewContextMessage(session *discordgo.Session, message *d
 	if !context.IsPrivate {
 		context.Member = message.Member
 	}
-	return context
+	return true
 }
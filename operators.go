@@ -0,0 +1,229 @@
+package multiplexer
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidUserID represents the error returned when a user ID is malformed.
+var ErrInvalidUserID = errors.New("invalid user ID")
+
+// OperatorStore persists operator IDs.
+type OperatorStore interface {
+	// LoadOperators returns persisted operator IDs.
+	LoadOperators() ([]string, error)
+	// SaveOperators replaces persisted operator IDs.
+	SaveOperators(ids []string) error
+}
+
+// JSONFileOperatorStore is an OperatorStore persisting operator IDs in a JSON file.
+type JSONFileOperatorStore struct {
+	// Path is the path of the JSON file, created on first save.
+	Path string
+}
+
+// NewJSONFileOperatorStore returns an OperatorStore persisting to the JSON file at path.
+func NewJSONFileOperatorStore(path string) *JSONFileOperatorStore {
+	return &JSONFileOperatorStore{Path: path}
+}
+
+// LoadOperators returns persisted operator IDs, none if the file does not exist.
+func (store *JSONFileOperatorStore) LoadOperators() ([]string, error) {
+	data, err := ioutil.ReadFile(store.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	return ids, json.Unmarshal(data, &ids)
+}
+
+// SaveOperators atomically replaces the JSON file with ids.
+func (store *JSONFileOperatorStore) SaveOperators(ids []string) error {
+	data, err := json.MarshalIndent(ids, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.Path, data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path.
+func writeFileAtomic(path string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// loadOperators adds operators persisted in the configured store.
+func (mux *Multiplexer) loadOperators() error {
+	if mux.operatorStore == nil {
+		return nil
+	}
+	ids, err := mux.operatorStore.LoadOperators()
+	if err != nil {
+		return err
+	}
+	mux.operators.Add(ids...)
+	mux.runtimeOperators.Add(ids...)
+	return nil
+}
+
+// saveOperators persists operators added at runtime to the configured store,
+// operators configured through options are not persisted.
+func (mux *Multiplexer) saveOperators() error {
+	if mux.operatorStore == nil {
+		return nil
+	}
+	return mux.operatorStore.SaveOperators(mux.runtimeOperators.Slice())
+}
+
+// AddOperator grants operator privilege to a user at runtime and persists the change.
+// Actor is the ID of the user making the change, recorded in the log.
+func (mux *Multiplexer) AddOperator(actor, id string) error {
+	if id == "" {
+		return ErrInvalidUserID
+	}
+	mux.operatorMutex.Lock()
+	defer mux.operatorMutex.Unlock()
+	if mux.operators.Has(id) {
+		return nil
+	}
+	mux.operators.Add(id)
+	mux.runtimeOperators.Add(id)
+	if err := mux.saveOperators(); err != nil {
+		mux.operators.Remove(id)
+		mux.runtimeOperators.Remove(id)
+		return err
+	}
+	mux.Logger().Infof("Operator %s added by %s", id, actorName(actor))
+	return nil
+}
+
+// RemoveOperator revokes operator privilege from a user at runtime and persists the change.
+// Operators configured through options are revoked until restart only.
+func (mux *Multiplexer) RemoveOperator(actor, id string) error {
+	mux.operatorMutex.Lock()
+	defer mux.operatorMutex.Unlock()
+	if !mux.operators.Has(id) {
+		return nil
+	}
+	mux.operators.Remove(id)
+	if !mux.runtimeOperators.Has(id) {
+		mux.Logger().Infof("Operator %s removed by %s until restart", id, actorName(actor))
+		return nil
+	}
+	mux.runtimeOperators.Remove(id)
+	if err := mux.saveOperators(); err != nil {
+		mux.operators.Add(id)
+		mux.runtimeOperators.Add(id)
+		return err
+	}
+	mux.Logger().Infof("Operator %s removed by %s", id, actorName(actor))
+	return nil
+}
+
+// ListOperators returns sorted IDs of operators, administrators are not included.
+func (mux *Multiplexer) ListOperators() []string {
+	return mux.operators.Slice()
+}
+
+// parseUserID returns the ID of a user mention or a numerical ID, in the formats accepted by GetMember.
+// Role and channel mentions are rejected.
+func parseUserID(query string) (string, bool) {
+	id := query
+	if strings.HasPrefix(query, "<@") && strings.HasSuffix(query, ">") {
+		id = strings.TrimPrefix(strings.TrimSuffix(strings.TrimPrefix(query, "<@"), ">"), "!")
+	}
+	if len(id) < 17 || len(id) > 20 {
+		return "", false
+	}
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+	return id, true
+}
+
+// actorName returns the name of an actor for audit logs.
+func actorName(actor string) string {
+	if actor == "" {
+		return "system"
+	}
+	return actor
+}
+
+// registerOperatorRoutes registers the administrator-only operator management route.
+func (mux *Multiplexer) registerOperatorRoutes(category *CommandCategory) {
+	mux.Route(&Route{
+		Pattern:     "operator",
+		Description: "Add, remove or list operators.",
+		Category:    category,
+		Handler:     mux.handleOperatorRoute,
+		Privilege:   PrivilegeAdministrator,
+	})
+}
+
+// handleOperatorRoute handles the operator management route.
+func (mux *Multiplexer) handleOperatorRoute(context *Context) {
+	if len(context.Fields) < 2 {
+		context.SendMessage(InvalidArgument)
+		return
+	}
+
+	switch context.Fields[1] {
+	case "list":
+		ids := mux.ListOperators()
+		if len(ids) == 0 {
+			context.SendMessage("There are no operators.")
+			return
+		}
+		mentions := make([]string, len(ids))
+		for i, id := range ids {
+			mentions[i] = "<@" + id + ">"
+		}
+		context.SendMessage("Operators: " + strings.Join(mentions, ", "))
+	case "add", "remove":
+		if len(context.Fields) != 3 {
+			context.SendMessage(InvalidArgument)
+			return
+		}
+		id, ok := parseUserID(context.Fields[2])
+		if !ok {
+			context.SendMessage(MissingUser)
+			return
+		}
+		var err error
+		if context.Fields[1] == "add" {
+			err = mux.AddOperator(context.User.ID, id)
+		} else {
+			err = mux.RemoveOperator(context.User.ID, id)
+		}
+		if !context.HandleError(err) {
+			return
+		}
+		context.SendMessage("Operators updated.")
+	default:
+		context.SendMessage(InvalidArgument)
+	}
+}
@@ -0,0 +1,587 @@
+package multiplexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrNilCategory represents the error returned when a nil category is registered.
+var ErrNilCategory = errors.New("category is nil")
+
+// ErrDuplicateCategory represents the error returned when a category title is registered twice.
+var ErrDuplicateCategory = errors.New("duplicate category")
+
+// ErrAlreadyStarted represents the error returned when configuring a multiplexer that has handlers registered.
+var ErrAlreadyStarted = errors.New("multiplexer already started")
+
+// ErrInvalidOption represents the error returned when an option or a combination of options is invalid.
+var ErrInvalidOption = errors.New("invalid option")
+
+// DispatchPolicy controls which messages are dispatched to command routes.
+// The zero value matches the behaviour of previous releases.
+type DispatchPolicy struct {
+	// AllowBots dispatches commands issued by other bot users.
+	AllowBots bool
+	// IgnoreMentions stops treating a mention of the bot as a command prefix.
+	IgnoreMentions bool
+	// SilentNotFound skips NoCommandMatched when no route matches.
+	SilentNotFound bool
+	// SilentDisabled ignores disabled routes instead of replying with FeatureDisabled.
+	SilentDisabled bool
+}
+
+// PrefixStore provides guild-specific command prefixes.
+type PrefixStore interface {
+	// GuildPrefix returns the prefix of a guild and whether one is set.
+	GuildPrefix(guildID string) (string, bool)
+}
+
+// Options holds the configuration of a Multiplexer.
+type Options struct {
+	// Prefix is the default command prefix.
+	Prefix string
+	// Categories is the ordered slice of categories to register, DefaultCategories is not implied.
+	Categories []*CommandCategory
+	// Administrators is a slice of administrator user IDs.
+	Administrators []string
+	// Operators is a slice of operator user IDs.
+	Operators []string
+	// OperatorStore persists operators managed at runtime, operators are not persisted if nil.
+	OperatorStore OperatorStore
+	// OperatorRoutes registers the administrator-only operator management route in the category if not nil.
+	OperatorRoutes *CommandCategory
+	// ApplicationOwners adds owners of the application, or members of its team, to administrators,
+	// fetched in the background on the first Ready.
+	ApplicationOwners bool
+	// HomeGuild is the ID of the guild where OperatorRoles are looked up.
+	HomeGuild string
+	// OperatorRoles is a slice of role IDs in HomeGuild granting operator privilege.
+	OperatorRoles []string
+	// ManagerRoles stores roles delegated bot manager privilege per guild, in memory if nil.
+	ManagerRoles ManagerRoleStore
+	// Matcher matches message text to a route, FuzzyMatcher if nil.
+	Matcher Matcher
+	// Policy controls which messages are dispatched to routes.
+	Policy DispatchPolicy
+	// Dispatcher configures bounded worker pools processing events, events are processed on
+	// unbounded goroutines and commands on the session's event goroutine if nil.
+	Dispatcher *DispatcherConfig
+	// CommandTimeout is the deadline of routes without a Timeout, none if zero.
+	CommandTimeout time.Duration
+	// MessageCacheTTL is how long messages fetched for reaction events are reused,
+	// DefaultMessageCacheTTL if zero and disabled if negative.
+	MessageCacheTTL time.Duration
+	// MessageHistory enables recording recent messages for MessageDelete and MessageUpdate hooks.
+	MessageHistory *HistoryConfig
+	// MessageSplit configures how text too long for a single message is sent, defaults apply if nil.
+	MessageSplit *SplitConfig
+	// ErrorReporter handles errors of routes and hooks, DefaultErrorReporter if nil.
+	ErrorReporter ErrorReporter
+	// SlowHook is the duration above which hook calls are logged as slow, none if zero.
+	SlowHook time.Duration
+	// MaxWaiters is the amount of pending awaits allowed per user, DefaultMaxWaiters if zero.
+	MaxWaiters int
+	// ConfirmBypass is the flag operators pass to skip confirmation of routes, such as "--yes", none if empty.
+	ConfirmBypass string
+	// Logger is the logger of the multiplexer, the package-level logger if nil.
+	Logger Logger
+	// PrefixStore provides guild-specific prefixes, GetPrefix is used if nil.
+	PrefixStore PrefixStore
+	// FeatureStore stores routes and categories enabled per guild and channel, in memory if nil.
+	FeatureStore FeatureStore
+	// OverrideStore stores permission overrides per guild, in memory if nil.
+	OverrideStore OverrideStore
+	// BlocklistStore persists the blocklist, the blocklist is not persisted if nil.
+	BlocklistStore BlocklistStore
+	// WizardStore persists progress of wizards, wizards are not persisted if nil.
+	WizardStore WizardStore
+	// Store backs PrefixStore, ManagerRoles, FeatureStore, OverrideStore, OperatorStore, BlocklistStore and WizardStore
+	// through Settings where they are nil.
+	Store Store
+	// BlockHooks skips hooks for events of blocked users, guilds and channels.
+	BlockHooks bool
+}
+
+// Option configures Options passed to New.
+type Option func(options *Options) error
+
+// WithPrefix sets the default command prefix.
+func WithPrefix(prefix string) Option {
+	return func(options *Options) error {
+		options.Prefix = prefix
+		return nil
+	}
+}
+
+// WithCategories appends categories to be registered in order, New then registers no DefaultCategories
+// unless WithDefaultCategories is passed as well.
+func WithCategories(categories ...*CommandCategory) Option {
+	return func(options *Options) error {
+		if options.Categories == nil {
+			options.Categories = []*CommandCategory{}
+		}
+		options.Categories = append(options.Categories, categories...)
+		return nil
+	}
+}
+
+// WithDefaultCategories appends copies of the DefaultCategories preset.
+func WithDefaultCategories() Option {
+	return WithCategories(DefaultCategories()...)
+}
+
+// WithAdministrators appends administrator user IDs.
+func WithAdministrators(ids ...string) Option {
+	return func(options *Options) error {
+		for _, id := range ids {
+			if id == "" {
+				return fmt.Errorf("%w: empty administrator ID", ErrInvalidOption)
+			}
+		}
+		options.Administrators = append(options.Administrators, ids...)
+		return nil
+	}
+}
+
+// WithOperators appends operator user IDs.
+func WithOperators(ids ...string) Option {
+	return func(options *Options) error {
+		for _, id := range ids {
+			if id == "" {
+				return fmt.Errorf("%w: empty operator ID", ErrInvalidOption)
+			}
+		}
+		options.Operators = append(options.Operators, ids...)
+		return nil
+	}
+}
+
+// WithOperatorStore sets the store persisting operators managed at runtime.
+func WithOperatorStore(store OperatorStore) Option {
+	return func(options *Options) error {
+		if store == nil {
+			return fmt.Errorf("%w: operator store is nil", ErrInvalidOption)
+		}
+		options.OperatorStore = store
+		return nil
+	}
+}
+
+// WithOperatorRoutes registers the administrator-only operator management route in category.
+func WithOperatorRoutes(category *CommandCategory) Option {
+	return func(options *Options) error {
+		if category == nil {
+			return fmt.Errorf("%w: %s", ErrInvalidOption, ErrNilCategory)
+		}
+		options.OperatorRoutes = category
+		return nil
+	}
+}
+
+// WithApplicationOwners adds owners of the application to administrators, fetched in the background on the first Ready.
+func WithApplicationOwners() Option {
+	return func(options *Options) error {
+		options.ApplicationOwners = true
+		return nil
+	}
+}
+
+// WithOperatorRoles grants operator privilege to holders of roles in the home guild.
+// Members of the home guild must be cached in the state for roles to be considered.
+func WithOperatorRoles(homeGuildID string, roleIDs ...string) Option {
+	return func(options *Options) error {
+		if homeGuildID == "" {
+			return fmt.Errorf("%w: empty home guild ID", ErrInvalidOption)
+		}
+		if options.HomeGuild != "" && options.HomeGuild != homeGuildID {
+			return fmt.Errorf("%w: conflicting home guilds", ErrInvalidOption)
+		}
+		options.HomeGuild = homeGuildID
+		options.OperatorRoles = append(options.OperatorRoles, roleIDs...)
+		return nil
+	}
+}
+
+// WithManagerRoleStore sets the store of delegated manager roles.
+func WithManagerRoleStore(store ManagerRoleStore) Option {
+	return func(options *Options) error {
+		if store == nil {
+			return fmt.Errorf("%w: manager role store is nil", ErrInvalidOption)
+		}
+		options.ManagerRoles = store
+		return nil
+	}
+}
+
+// WithMatcher sets the route matcher.
+func WithMatcher(matcher Matcher) Option {
+	return func(options *Options) error {
+		if matcher == nil {
+			return fmt.Errorf("%w: matcher is nil", ErrInvalidOption)
+		}
+		options.Matcher = matcher
+		return nil
+	}
+}
+
+// WithDispatchPolicy sets the dispatch policy.
+func WithDispatchPolicy(policy DispatchPolicy) Option {
+	return func(options *Options) error {
+		options.Policy = policy
+		return nil
+	}
+}
+
+// WithDispatcher processes events and commands on bounded worker pools.
+func WithDispatcher(config DispatcherConfig) Option {
+	return func(options *Options) error {
+		options.Dispatcher = &config
+		return nil
+	}
+}
+
+// WithCommandTimeout sets the deadline of routes without a Timeout.
+func WithCommandTimeout(timeout time.Duration) Option {
+	return func(options *Options) error {
+		if timeout < 0 {
+			return fmt.Errorf("%w: negative command timeout", ErrInvalidOption)
+		}
+		options.CommandTimeout = timeout
+		return nil
+	}
+}
+
+// WithMessageCacheTTL sets how long messages fetched for reaction events are reused, a negative TTL disables reuse.
+func WithMessageCacheTTL(ttl time.Duration) Option {
+	return func(options *Options) error {
+		options.MessageCacheTTL = ttl
+		return nil
+	}
+}
+
+// WithMessageHistory records recent messages so MessageDelete and MessageUpdate hooks receive the previous message.
+func WithMessageHistory(config HistoryConfig) Option {
+	return func(options *Options) error {
+		options.MessageHistory = &config
+		return nil
+	}
+}
+
+// WithMessageSplit configures how text too long for a single message is split or uploaded as a file.
+func WithMessageSplit(config SplitConfig) Option {
+	return func(options *Options) error {
+		options.MessageSplit = &config
+		return nil
+	}
+}
+
+// WithErrorReporter sets the handler of errors of routes and hooks.
+func WithErrorReporter(reporter ErrorReporter) Option {
+	return func(options *Options) error {
+		if reporter == nil {
+			return fmt.Errorf("%w: error reporter is nil", ErrInvalidOption)
+		}
+		options.ErrorReporter = reporter
+		return nil
+	}
+}
+
+// WithSlowHook logs hook calls taking longer than threshold.
+func WithSlowHook(threshold time.Duration) Option {
+	return func(options *Options) error {
+		if threshold < 0 {
+			return fmt.Errorf("%w: negative slow hook threshold", ErrInvalidOption)
+		}
+		options.SlowHook = threshold
+		return nil
+	}
+}
+
+// WithMaxWaiters sets the amount of pending awaits allowed per user.
+func WithMaxWaiters(max int) Option {
+	return func(options *Options) error {
+		if max < 0 {
+			return fmt.Errorf("%w: negative waiter limit", ErrInvalidOption)
+		}
+		options.MaxWaiters = max
+		return nil
+	}
+}
+
+// WithConfirmBypass sets the flag operators pass to skip confirmation of routes.
+func WithConfirmBypass(flag string) Option {
+	return func(options *Options) error {
+		if strings.ContainsAny(flag, " \t\n") {
+			return fmt.Errorf("%w: confirm bypass flag %q contains whitespace", ErrInvalidOption, flag)
+		}
+		options.ConfirmBypass = flag
+		return nil
+	}
+}
+
+// WithLogger sets the logger.
+func WithLogger(logger Logger) Option {
+	return func(options *Options) error {
+		if logger == nil {
+			return fmt.Errorf("%w: logger is nil", ErrInvalidOption)
+		}
+		options.Logger = logger
+		return nil
+	}
+}
+
+// WithPrefixStore sets the store of guild-specific prefixes.
+func WithPrefixStore(store PrefixStore) Option {
+	return func(options *Options) error {
+		if store == nil {
+			return fmt.Errorf("%w: prefix store is nil", ErrInvalidOption)
+		}
+		options.PrefixStore = store
+		return nil
+	}
+}
+
+// WithFeatureStore sets the store of routes and categories enabled per guild and channel.
+func WithFeatureStore(store FeatureStore) Option {
+	return func(options *Options) error {
+		if store == nil {
+			return fmt.Errorf("%w: feature store is nil", ErrInvalidOption)
+		}
+		options.FeatureStore = store
+		return nil
+	}
+}
+
+// WithOverrideStore sets the store of permission overrides.
+func WithOverrideStore(store OverrideStore) Option {
+	return func(options *Options) error {
+		if store == nil {
+			return fmt.Errorf("%w: override store is nil", ErrInvalidOption)
+		}
+		options.OverrideStore = store
+		return nil
+	}
+}
+
+// WithBlocklistStore sets the store persisting the blocklist.
+func WithBlocklistStore(store BlocklistStore) Option {
+	return func(options *Options) error {
+		if store == nil {
+			return fmt.Errorf("%w: blocklist store is nil", ErrInvalidOption)
+		}
+		options.BlocklistStore = store
+		return nil
+	}
+}
+
+// WithWizardStore sets the store persisting progress of wizards.
+func WithWizardStore(store WizardStore) Option {
+	return func(options *Options) error {
+		if store == nil {
+			return fmt.Errorf("%w: wizard store is nil", ErrInvalidOption)
+		}
+		options.WizardStore = store
+		return nil
+	}
+}
+
+// WithStore sets the Store backing all settings not configured with a dedicated store.
+func WithStore(store Store) Option {
+	return func(options *Options) error {
+		if store == nil {
+			return fmt.Errorf("%w: store is nil", ErrInvalidOption)
+		}
+		options.Store = store
+		return nil
+	}
+}
+
+// WithBlockHooks skips hooks for events of blocked users, guilds and channels.
+func WithBlockHooks() Option {
+	return func(options *Options) error {
+		options.BlockHooks = true
+		return nil
+	}
+}
+
+// validate checks options for invalid combinations.
+func (options Options) validate() error {
+	if strings.TrimLeftFunc(options.Prefix, unicode.IsSpace) != options.Prefix {
+		return fmt.Errorf("%w: prefix %q begins with whitespace", ErrInvalidOption, options.Prefix)
+	}
+	if options.Dispatcher != nil {
+		if err := options.Dispatcher.validate(); err != nil {
+			return err
+		}
+	}
+	if options.MessageHistory != nil {
+		if err := options.MessageHistory.validate(); err != nil {
+			return err
+		}
+	}
+	if options.MessageSplit != nil {
+		if err := options.MessageSplit.validate(); err != nil {
+			return err
+		}
+	}
+	if len(options.OperatorRoles) > 0 && options.HomeGuild == "" {
+		return fmt.Errorf("%w: operator roles without a home guild", ErrInvalidOption)
+	}
+	if options.Prefix == "" && options.PrefixStore == nil && options.Policy.IgnoreMentions {
+		return fmt.Errorf("%w: no prefix is set and mentions are ignored", ErrInvalidOption)
+	}
+	return nil
+}
+
+// NewWithOptions returns a command router configured with options.
+func NewWithOptions(options Options) (*Multiplexer, error) {
+	var settings *Settings
+	if options.Store != nil {
+		settings = NewSettings(options.Store)
+		settings.logger = options.Logger
+		if options.PrefixStore == nil {
+			options.PrefixStore = settings
+		}
+		if options.ManagerRoles == nil {
+			options.ManagerRoles = settings
+		}
+		if options.FeatureStore == nil {
+			options.FeatureStore = settings
+		}
+		if options.OverrideStore == nil {
+			options.OverrideStore = settings
+		}
+		if options.OperatorStore == nil {
+			options.OperatorStore = settings
+		}
+		if options.BlocklistStore == nil {
+			options.BlocklistStore = settings
+		}
+		if options.WizardStore == nil {
+			options.WizardStore = settings
+		}
+	}
+	if err := options.validate(); err != nil {
+		if settings != nil {
+			settings.Close()
+		}
+		return nil, err
+	}
+	mux := &Multiplexer{
+		prefix:            options.Prefix,
+		applicationOwners: options.ApplicationOwners,
+		operatorStore:     options.OperatorStore,
+		homeGuild:         options.HomeGuild,
+		managerRoles:      options.ManagerRoles,
+		matcher:           options.Matcher,
+		policy:            options.Policy,
+		logger:            options.Logger,
+		prefixStore:       options.PrefixStore,
+		features:          options.FeatureStore,
+		overrides:         options.OverrideStore,
+		blocklistStore:    options.BlocklistStore,
+		wizardStore:       options.WizardStore,
+		blockHooks:        options.BlockHooks,
+		settings:          settings,
+		commandTimeout:    options.CommandTimeout,
+		errorReporter:     options.ErrorReporter,
+		slowHook:          options.SlowHook,
+		maxWaiters:        options.MaxWaiters,
+		confirmBypass:     options.ConfirmBypass,
+	}
+	mux.lifecycle, mux.cancelLifecycle = context.WithCancel(context.Background())
+	if mux.maxWaiters == 0 {
+		mux.maxWaiters = DefaultMaxWaiters
+	}
+	mux.messages.ttl = options.MessageCacheTTL
+	if mux.messages.ttl == 0 {
+		mux.messages.ttl = DefaultMessageCacheTTL
+	}
+	if options.MessageHistory != nil {
+		mux.history = newMessageHistory(*options.MessageHistory)
+	}
+	if options.MessageSplit != nil {
+		mux.split = *options.MessageSplit
+	}
+	mux.administrators.Add(options.Administrators...)
+	mux.operators.Add(options.Operators...)
+	mux.operatorRoles.Add(options.OperatorRoles...)
+	mux.EventHandlers = []interface{}{
+		mux.handleMessageCommand,
+		mux.onReady,
+		mux.onGuildMemberAdd,
+		mux.onGuildMemberRemove,
+		mux.onGuildDelete,
+		mux.onMessageCreate,
+		mux.onMessageDelete,
+		mux.onMessageUpdate,
+		mux.onMessageReactionAdd,
+		mux.onMessageReactionRemove,
+		mux.onVoiceStateUpdate,
+		mux.onChannelCreate,
+		mux.onChannelUpdate,
+		mux.onChannelDelete,
+		mux.onGuildCreate,
+		mux.onGuildUpdate,
+		mux.onGuildRoleCreate,
+		mux.onGuildRoleUpdate,
+		mux.onGuildRoleDelete,
+		mux.onGuildMemberUpdate,
+		mux.onGuildBanAdd,
+		mux.onGuildBanRemove,
+		mux.onPresenceUpdate,
+		mux.onTypingStart,
+		mux.onInviteCreate,
+		mux.onInviteDelete,
+		mux.onEvent,
+	}
+	if err := mux.load(options); err != nil {
+		mux.release()
+		return nil, err
+	}
+	if options.Dispatcher != nil {
+		mux.dispatcher = newDispatcher(*options.Dispatcher)
+	}
+	return mux, nil
+}
+
+// load registers categories and routes of options and loads persisted state.
+func (mux *Multiplexer) load(options Options) error {
+	for _, category := range options.Categories {
+		if err := mux.RegisterCategory(category); err != nil {
+			return err
+		}
+	}
+	if err := mux.loadOperators(); err != nil {
+		return err
+	}
+	if err := mux.loadBlocklist(); err != nil {
+		return err
+	}
+	if err := mux.loadWizards(); err != nil {
+		return err
+	}
+	if options.OperatorRoutes != nil {
+		mux.registerOperatorRoutes(options.OperatorRoutes)
+	}
+	return nil
+}
+
+// RegisterCategory registers a category after all previously registered ones.
+func (mux *Multiplexer) RegisterCategory(category *CommandCategory) error {
+	if mux.Started() {
+		return ErrAlreadyStarted
+	}
+	if category == nil {
+		return ErrNilCategory
+	}
+	if mux.Category(category.Title) != nil {
+		return fmt.Errorf("%w: %s", ErrDuplicateCategory, category.Title)
+	}
+	mux.categories = append(mux.categories, category)
+	return nil
+}
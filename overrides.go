@@ -0,0 +1,263 @@
+package multiplexer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidOverride represents the error returned when an override is malformed.
+var ErrInvalidOverride = errors.New("invalid override")
+
+// OverrideTarget is the kind of entity an Override applies to.
+type OverrideTarget string
+
+// Targets of overrides in descending precedence, the @everyone role has the lowest precedence.
+const (
+	OverrideUser    OverrideTarget = "user"
+	OverrideRole    OverrideTarget = "role"
+	OverrideChannel OverrideTarget = "channel"
+)
+
+// OverrideEffect is the effect of an override on a route.
+type OverrideEffect int
+
+// Effects of overrides.
+const (
+	// OverrideInherit leaves the decision to the route's built-in guard.
+	OverrideInherit OverrideEffect = iota
+	// OverrideAllow allows the route regardless of the channel permission it requires.
+	OverrideAllow
+	// OverrideDeny denies the route.
+	OverrideDeny
+)
+
+// String returns the name of the effect.
+func (effect OverrideEffect) String() string {
+	switch effect {
+	case OverrideInherit:
+		return "inherit"
+	case OverrideAllow:
+		return "allow"
+	case OverrideDeny:
+		return "deny"
+	default:
+		return "unknown"
+	}
+}
+
+// Override allows or denies a route pattern or category title for a user, a role or everyone in a channel.
+//
+// Like Discord permission overwrites, user overrides take precedence over role overrides,
+// then channel overrides, then overrides of the @everyone role, whose ID is the guild ID.
+// An allow among overrides of several roles of a member wins over a deny.
+// Route overrides take precedence over category overrides of the same target.
+type Override struct {
+	Target OverrideTarget `json:"target"`
+	ID     string         `json:"id"`
+	// Feature is a route pattern or category title, prefixed with "route:" or "category:" if a route
+	// and a category share the name.
+	Feature string         `json:"feature"`
+	Effect  OverrideEffect `json:"effect"`
+}
+
+// appliesTo checks if the override applies to a route by pattern or by category.
+func (override *Override) appliesTo(route *Route, byRoute bool) bool {
+	feature := override.Feature
+	switch {
+	case strings.HasPrefix(feature, "route:"):
+		return byRoute && strings.EqualFold(strings.TrimPrefix(feature, "route:"), route.Pattern)
+	case strings.HasPrefix(feature, "category:"):
+		feature = strings.TrimPrefix(feature, "category:")
+		return !byRoute && route.Category != nil && strings.EqualFold(feature, route.Category.Title)
+	}
+	if byRoute {
+		return strings.EqualFold(feature, route.Pattern)
+	}
+	return route.Category != nil && strings.EqualFold(feature, route.Category.Title)
+}
+
+// OverrideDecision is the effective outcome of overrides for a route in a context.
+type OverrideDecision struct {
+	Effect OverrideEffect
+	// Override is the deciding override, nil if none applies.
+	Override *Override
+	// Reason explains the decision.
+	Reason string
+}
+
+// OverrideStore stores permission overrides of each guild.
+type OverrideStore interface {
+	// Overrides returns overrides of a guild.
+	Overrides(guildID string) ([]Override, error)
+	// SetOverrides replaces overrides of a guild.
+	SetOverrides(guildID string, overrides []Override) error
+}
+
+// memoryOverrideStore is the in-memory OverrideStore used when none is configured.
+type memoryOverrideStore struct {
+	mutex     sync.RWMutex
+	overrides map[string][]Override
+}
+
+func (store *memoryOverrideStore) Overrides(guildID string) ([]Override, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return append([]Override(nil), store.overrides[guildID]...), nil
+}
+
+func (store *memoryOverrideStore) SetOverrides(guildID string, overrides []Override) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.overrides == nil {
+		store.overrides = make(map[string][]Override)
+	}
+	if len(overrides) == 0 {
+		delete(store.overrides, guildID)
+		return nil
+	}
+	store.overrides[guildID] = append([]Override(nil), overrides...)
+	return nil
+}
+
+// overrideStore returns the configured OverrideStore.
+func (mux *Multiplexer) overrideStore() OverrideStore {
+	if mux.overrides == nil {
+		return &mux.defaultOverrides
+	}
+	return mux.overrides
+}
+
+// Overrides returns permission overrides of a guild.
+func (mux *Multiplexer) Overrides(guildID string) ([]Override, error) {
+	return mux.overrideStore().Overrides(guildID)
+}
+
+// SetOverride adds an override to a guild, replacing one with the same target and feature.
+// An override with OverrideInherit removes the existing one. Features are resolved like EnableFeature,
+// names matching both a route and a category must be prefixed with "route:" or "category:".
+func (mux *Multiplexer) SetOverride(guildID string, override Override) error {
+	switch override.Target {
+	case OverrideUser, OverrideRole, OverrideChannel:
+	default:
+		return fmt.Errorf("%w: unknown target %q", ErrInvalidOverride, override.Target)
+	}
+	if override.ID == "" {
+		return fmt.Errorf("%w: empty target ID", ErrInvalidOverride)
+	}
+	name, isCategory, err := mux.resolveFeature(override.Feature)
+	if err != nil {
+		return err
+	}
+	override.Feature = name
+	if mux.sharedFeatureName(name) {
+		override.Feature = "route:" + name
+		if isCategory {
+			override.Feature = "category:" + name
+		}
+	}
+
+	mux.overrideMutex.Lock()
+	defer mux.overrideMutex.Unlock()
+	overrides, err := mux.overrideStore().Overrides(guildID)
+	if err != nil {
+		return err
+	}
+	result := overrides[:0]
+	for _, existing := range overrides {
+		if existing.Target != override.Target || existing.ID != override.ID ||
+			!strings.EqualFold(existing.Feature, override.Feature) {
+			result = append(result, existing)
+		}
+	}
+	if override.Effect != OverrideInherit {
+		result = append(result, override)
+	}
+	return mux.overrideStore().SetOverrides(guildID, result)
+}
+
+// sharedFeatureName checks if a route pattern and a category title match a name, ignoring case like overrides do.
+func (mux *Multiplexer) sharedFeatureName(name string) bool {
+	if mux.Category(name) == nil {
+		return false
+	}
+	for _, route := range mux.routes {
+		if strings.EqualFold(route.Pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// OverrideDecision returns the effective override decision for a route in the context.
+func (context *Context) OverrideDecision(route *Route) OverrideDecision {
+	if context.IsPrivate || context.Guild == nil || context.Guild.ID == "" || context.User == nil {
+		return OverrideDecision{Effect: OverrideInherit, Reason: "not in a guild"}
+	}
+	if context.IsOperator() {
+		return OverrideDecision{Effect: OverrideAllow, Reason: "operators bypass overrides"}
+	}
+	if context.IsGuildAdministrator() {
+		return OverrideDecision{Effect: OverrideAllow, Reason: "guild administrators bypass overrides"}
+	}
+
+	guildID := context.Guild.ID
+	overrides, err := context.Multiplexer.Overrides(guildID)
+	if err != nil {
+		context.Multiplexer.Logger().Errorf("Error getting overrides of guild %s, %s", guildID, err)
+		return OverrideDecision{Effect: OverrideInherit, Reason: "error getting overrides"}
+	}
+
+	var roles []string
+	if member := context.member(); member != nil {
+		roles = member.Roles
+	}
+	channelID := context.channelID()
+	levels := []func(override *Override) bool{
+		func(override *Override) bool {
+			return override.Target == OverrideUser && override.ID == context.User.ID
+		},
+		func(override *Override) bool {
+			if override.Target != OverrideRole || override.ID == guildID {
+				return false
+			}
+			for _, roleID := range roles {
+				if roleID == override.ID {
+					return true
+				}
+			}
+			return false
+		},
+		func(override *Override) bool {
+			return override.Target == OverrideChannel && override.ID == channelID
+		},
+		func(override *Override) bool {
+			return override.Target == OverrideRole && override.ID == guildID
+		},
+	}
+
+	for _, matches := range levels {
+		for _, byRoute := range []bool{true, false} {
+			var deciding *Override
+			for i := range overrides {
+				override := &overrides[i]
+				if !matches(override) || !override.appliesTo(route, byRoute) {
+					continue
+				}
+				if deciding == nil || override.Effect == OverrideAllow {
+					deciding = override
+				}
+			}
+			if deciding != nil {
+				return OverrideDecision{
+					Effect:   deciding.Effect,
+					Override: deciding,
+					Reason: fmt.Sprintf("%s override of %s %s on %s",
+						deciding.Effect, deciding.Target, deciding.ID, deciding.Feature),
+				}
+			}
+		}
+	}
+	return OverrideDecision{Effect: OverrideInherit, Reason: "no override applies"}
+}
@@ -0,0 +1,38 @@
+package multiplexer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetOverrideResolvesFeatures(t *testing.T) {
+	mux, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := mux.Route(&Route{Pattern: "music", Category: NewCategory("Music", "")})
+	other := mux.Route(&Route{Pattern: "queue", Category: route.Category})
+
+	override := Override{Target: OverrideUser, ID: "1", Feature: "music", Effect: OverrideDeny}
+	if err = mux.SetOverride("guild", override); !errors.Is(err, ErrAmbiguousFeature) {
+		t.Errorf("ambiguous feature accepted, %v", err)
+	}
+	override.Feature = "route:music"
+	if err = mux.SetOverride("guild", override); err != nil {
+		t.Fatal(err)
+	}
+	overrides, _ := mux.Overrides("guild")
+	if len(overrides) != 1 || !overrides[0].appliesTo(route, true) ||
+		overrides[0].appliesTo(route, false) || overrides[0].appliesTo(other, false) {
+		t.Errorf("route override applies to its category, %+v", overrides)
+	}
+
+	override.Feature = "category:MUSIC"
+	if err = mux.SetOverride("guild", override); err != nil {
+		t.Fatal(err)
+	}
+	overrides, _ = mux.Overrides("guild")
+	if len(overrides) != 2 || !overrides[1].appliesTo(other, false) || overrides[1].appliesTo(route, true) {
+		t.Errorf("category override not resolved, %+v", overrides)
+	}
+}
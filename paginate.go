@@ -0,0 +1,116 @@
+package multiplexer
+
+import (
+	"errors"
+	"fmt"
+	"git.randomchars.net/freenitori/embedutil"
+	"github.com/bwmarrin/discordgo"
+	"time"
+)
+
+// ErrNoPages represents the error returned when paginating no pages.
+var ErrNoPages = errors.New("no pages")
+
+// Emojis of pagination controls.
+const (
+	PageFirst    = "⏮️"
+	PagePrevious = "◀️"
+	PageNext     = "▶️"
+	PageLast     = "⏭️"
+	PageStop     = "⏹️"
+)
+
+// PaginateOptions configures a paginated message.
+type PaginateOptions struct {
+	// Content is the text sent along the embed.
+	Content string
+	// Users are IDs of users allowed to turn pages, the user causing the event if empty.
+	Users []string
+	// Anyone allows every user to turn pages, overriding Users.
+	Anyone bool
+	// Timeout stops pagination once no page has been turned for this long, DefaultMenuTimeout if zero.
+	Timeout time.Duration
+	// KeepReactions keeps the controls on the message once pagination stops.
+	KeepReactions bool
+}
+
+// pageEmbed returns a copy of a page with the page counter in its footer, pages must not be empty embeds.
+func pageEmbed(pages []embedutil.Embed, index int) embedutil.Embed {
+	embed := *pages[index].MessageEmbed
+	counter := fmt.Sprintf("Page %d/%d", index+1, len(pages))
+	if embed.Footer != nil && embed.Footer.Text != "" {
+		footer := *embed.Footer
+		footer.Text += " • " + counter
+		embed.Footer = &footer
+	} else {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: counter}
+	}
+	return embedutil.Embed{MessageEmbed: &embed}
+}
+
+// nonEmptyPages returns pages without empty embeds.
+func nonEmptyPages(pages []embedutil.Embed) []embedutil.Embed {
+	filtered := make([]embedutil.Embed, 0, len(pages))
+	for _, page := range pages {
+		if page.MessageEmbed != nil {
+			filtered = append(filtered, page)
+		}
+	}
+	return filtered
+}
+
+// SendPaginated sends the first of pages in the current channel with controls to turn pages.
+// Empty embeds are skipped. The returned menu is nil if there is only one page.
+func (context *Context) SendPaginated(pages []embedutil.Embed, options PaginateOptions) (*Menu, error) {
+	pages = nonEmptyPages(pages)
+	if len(pages) == 0 {
+		return nil, ErrNoPages
+	}
+	users := options.Users
+	if options.Anyone {
+		users = nil
+	} else if len(users) == 0 {
+		actor := context.Actor()
+		if actor == nil {
+			return nil, ErrUserNotFound
+		}
+		users = []string{actor.ID}
+	}
+	sent := context.SendEmbed(options.Content, pageEmbed(pages, 0))
+	if sent == nil {
+		return nil, ErrMessageNotSent
+	}
+	if len(pages) == 1 {
+		return nil, nil
+	}
+
+	// Handlers of a menu are called one at a time, so index needs no lock
+	var index int
+	turn := func(target func() int) func(context *Context, menu *Menu) error {
+		return func(context *Context, menu *Menu) error {
+			next := target()
+			if next < 0 || next >= len(pages) || next == index {
+				return nil
+			}
+			index = next
+			_, err := context.Session.ChannelMessageEditEmbed(sent.ChannelID, sent.ID, pageEmbed(pages, index).MessageEmbed)
+			return err
+		}
+	}
+	buttons := []MenuButton{
+		{Emoji: PageFirst, Handler: turn(func() int { return 0 })},
+		{Emoji: PagePrevious, Handler: turn(func() int { return index - 1 })},
+		{Emoji: PageNext, Handler: turn(func() int { return index + 1 })},
+		{Emoji: PageLast, Handler: turn(func() int { return len(pages) - 1 })},
+		{Emoji: PageStop, Handler: func(context *Context, menu *Menu) error {
+			menu.Close()
+			return nil
+		}},
+	}
+
+	return context.Multiplexer.NewMenu(context.Session, sent, buttons, MenuOptions{
+		Users:         users,
+		Timeout:       options.Timeout,
+		KeepReactions: options.KeepReactions,
+	})
+}
@@ -0,0 +1,41 @@
+package multiplexer
+
+import (
+	"git.randomchars.net/freenitori/embedutil"
+	"github.com/bwmarrin/discordgo"
+	"testing"
+)
+
+func TestPageEmbed(t *testing.T) {
+	pages := []embedutil.Embed{
+		{MessageEmbed: &discordgo.MessageEmbed{Title: "first"}},
+		{MessageEmbed: &discordgo.MessageEmbed{Title: "second", Footer: &discordgo.MessageEmbedFooter{Text: "footer"}}},
+	}
+
+	if footer := pageEmbed(pages, 0).Footer; footer == nil || footer.Text != "Page 1/2" {
+		t.Errorf("page counter not set, %+v", footer)
+	}
+	if footer := pageEmbed(pages, 1).Footer; footer == nil || footer.Text != "footer • Page 2/2" {
+		t.Errorf("page counter not appended to footer, %+v", footer)
+	}
+	if pages[1].Footer.Text != "footer" {
+		t.Errorf("page modified, footer %q", pages[1].Footer.Text)
+	}
+}
+
+func TestSendPaginatedInvalid(t *testing.T) {
+	context := &Context{Multiplexer: &Multiplexer{}}
+	if _, err := context.SendPaginated([]embedutil.Embed{{}, {}}, PaginateOptions{}); err != ErrNoPages {
+		t.Errorf("empty pages not rejected, %v", err)
+	}
+	if pages := nonEmptyPages([]embedutil.Embed{{}, {MessageEmbed: &discordgo.MessageEmbed{Title: "page"}}}); len(pages) != 1 {
+		t.Errorf("%d pages kept, want 1", len(pages))
+	}
+	if _, err := context.SendPaginated([]embedutil.Embed{{MessageEmbed: &discordgo.MessageEmbed{Title: "page"}}}, PaginateOptions{}); err != ErrUserNotFound {
+		t.Errorf("pages sent without a user to turn them, %v", err)
+	}
+	context.actor = &discordgo.User{ID: "reactor"}
+	if actor := context.Actor(); actor == nil || actor.ID != "reactor" {
+		t.Errorf("actor not returned, %+v", actor)
+	}
+}
@@ -0,0 +1,333 @@
+package multiplexer
+
+import (
+	"errors"
+	"github.com/bwmarrin/discordgo"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrPermissionDenied represents the error returned when a user is not allowed to perform an action.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// PrivilegeLevel represents the privilege of a user, higher levels include all lower ones.
+type PrivilegeLevel int
+
+// Privilege levels in ascending order.
+const (
+	// PrivilegeUser is the level of every user.
+	PrivilegeUser PrivilegeLevel = iota
+	// PrivilegeManager is the level of guild owners, guild administrators and holders of delegated manager roles.
+	PrivilegeManager
+	// PrivilegeOperator is the level of operators and holders of operator roles in the home guild.
+	PrivilegeOperator
+	// PrivilegeAdministrator is the level of system administrators.
+	PrivilegeAdministrator
+)
+
+// String returns the name of the privilege level.
+func (level PrivilegeLevel) String() string {
+	switch level {
+	case PrivilegeUser:
+		return "user"
+	case PrivilegeManager:
+		return "manager"
+	case PrivilegeOperator:
+		return "operator"
+	case PrivilegeAdministrator:
+		return "administrator"
+	default:
+		return "unknown"
+	}
+}
+
+// ManagerRoleStore stores roles delegated bot manager privilege in each guild.
+type ManagerRoleStore interface {
+	// ManagerRoles returns IDs of manager roles of a guild.
+	ManagerRoles(guildID string) ([]string, error)
+	// SetManagerRoles replaces manager roles of a guild.
+	SetManagerRoles(guildID string, roleIDs []string) error
+}
+
+// memoryManagerRoles is the in-memory ManagerRoleStore used when none is configured.
+type memoryManagerRoles struct {
+	mutex sync.RWMutex
+	roles map[string][]string
+}
+
+func (store *memoryManagerRoles) ManagerRoles(guildID string) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return append([]string(nil), store.roles[guildID]...), nil
+}
+
+func (store *memoryManagerRoles) SetManagerRoles(guildID string, roleIDs []string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.roles == nil {
+		store.roles = make(map[string][]string)
+	}
+	if len(roleIDs) == 0 {
+		delete(store.roles, guildID)
+		return nil
+	}
+	store.roles[guildID] = append([]string(nil), roleIDs...)
+	return nil
+}
+
+// IDSet is a concurrency-safe set of snowflake IDs, the zero value is an empty set ready to use.
+type IDSet struct {
+	mutex sync.RWMutex
+	ids   map[string]struct{}
+}
+
+// NewIDSet returns a set holding ids.
+func NewIDSet(ids ...string) *IDSet {
+	set := &IDSet{}
+	set.Add(ids...)
+	return set
+}
+
+// Add adds ids to the set, ignoring empty ones. It does nothing on a nil set.
+func (set *IDSet) Add(ids ...string) {
+	if set == nil {
+		return
+	}
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	if set.ids == nil {
+		set.ids = make(map[string]struct{})
+	}
+	for _, id := range ids {
+		if id != "" {
+			set.ids[id] = struct{}{}
+		}
+	}
+}
+
+// Remove removes ids from the set. It does nothing on a nil set.
+func (set *IDSet) Remove(ids ...string) {
+	if set == nil {
+		return
+	}
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	for _, id := range ids {
+		delete(set.ids, id)
+	}
+}
+
+// Has returns whether id is in the set, false for a nil set or an empty id.
+func (set *IDSet) Has(id string) bool {
+	if set == nil || id == "" {
+		return false
+	}
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	_, ok := set.ids[id]
+	return ok
+}
+
+// Len returns the amount of IDs in the set.
+func (set *IDSet) Len() int {
+	if set == nil {
+		return 0
+	}
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	return len(set.ids)
+}
+
+// Slice returns a sorted copy of IDs in the set.
+func (set *IDSet) Slice() []string {
+	if set == nil {
+		return nil
+	}
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	ids := make([]string, 0, len(set.ids))
+	for id := range set.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ApplicationOwners returns IDs of the owner of the current application, or all members of the owning team.
+func ApplicationOwners(session *discordgo.Session) ([]string, error) {
+	application, err := session.Application("@me")
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	if application.Team != nil {
+		ids = append(ids, application.Team.OwnerID)
+		for _, member := range application.Team.Members {
+			if member != nil && member.User != nil {
+				ids = append(ids, member.User.ID)
+			}
+		}
+	} else if application.Owner != nil {
+		ids = append(ids, application.Owner.ID)
+	}
+	return ids, nil
+}
+
+// loadApplicationOwners adds owners of the current application to administrators in the background.
+// Owners are fetched once, again on the next Ready only if fetching failed.
+func (mux *Multiplexer) loadApplicationOwners(session *discordgo.Session) {
+	if !atomic.CompareAndSwapInt32(&mux.ownersLoaded, 0, 1) {
+		return
+	}
+	if !mux.track() {
+		return
+	}
+	go mux.tracked(func() {
+		ids, err := ApplicationOwners(session)
+		if err != nil {
+			atomic.StoreInt32(&mux.ownersLoaded, 0)
+			mux.Logger().Errorf("Error fetching application owners, %s", err)
+			return
+		}
+		mux.administrators.Add(ids...)
+	})()
+}
+
+// managerRoleStore returns the configured ManagerRoleStore.
+func (mux *Multiplexer) managerRoleStore() ManagerRoleStore {
+	if mux.managerRoles == nil {
+		return &mux.defaultManagerRoles
+	}
+	return mux.managerRoles
+}
+
+// isRoleOperator checks if a user holds an operator role in the home guild, only cached members are considered.
+func (mux *Multiplexer) isRoleOperator(session *discordgo.Session, id string) bool {
+	if mux.homeGuild == "" || mux.operatorRoles.Len() == 0 || session == nil || session.State == nil {
+		return false
+	}
+	member, err := session.State.Member(mux.homeGuild, id)
+	if err != nil {
+		return false
+	}
+	for _, roleID := range member.Roles {
+		if mux.operatorRoles.Has(roleID) {
+			return true
+		}
+	}
+	return false
+}
+
+// member returns the member of the context user, looking up the state if not carried by the event.
+func (context *Context) member() *discordgo.Member {
+	if context.IsPrivate || context.Guild == nil || context.Guild.ID == "" || context.User == nil {
+		return nil
+	}
+	if context.Member != nil {
+		return context.Member
+	}
+	member, err := context.Session.State.Member(context.Guild.ID, context.User.ID)
+	if err != nil {
+		return nil
+	}
+	return member
+}
+
+// IsGuildAdministrator checks if the user owns the guild or holds a role with the administrator permission.
+func (context *Context) IsGuildAdministrator() bool {
+	member := context.member()
+	if member == nil {
+		return false
+	}
+	if context.Guild.OwnerID == context.User.ID {
+		return true
+	}
+	for _, role := range context.Guild.Roles {
+		if role.Permissions&discordgo.PermissionAdministrator == 0 {
+			continue
+		}
+		if role.ID == context.Guild.ID {
+			return true
+		}
+		for _, roleID := range member.Roles {
+			if roleID == role.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsManager checks if the user is a bot manager of the guild.
+func (context *Context) IsManager() bool {
+	if context.IsGuildAdministrator() {
+		return true
+	}
+	member := context.member()
+	if member == nil {
+		return false
+	}
+	roleIDs, err := context.Multiplexer.managerRoleStore().ManagerRoles(context.Guild.ID)
+	if err != nil {
+		context.Multiplexer.Logger().Errorf("Error getting manager roles of guild %s, %s", context.Guild.ID, err)
+		return false
+	}
+	for _, roleID := range roleIDs {
+		for _, memberRoleID := range member.Roles {
+			if roleID == memberRoleID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PrivilegeLevel returns the privilege level of the user in the context.
+func (context *Context) PrivilegeLevel() PrivilegeLevel {
+	switch {
+	case context.User == nil:
+		return PrivilegeUser
+	case context.IsAdministrator():
+		return PrivilegeAdministrator
+	case context.IsOperator():
+		return PrivilegeOperator
+	case context.IsManager():
+		return PrivilegeManager
+	default:
+		return PrivilegeUser
+	}
+}
+
+// ManagerRoles returns IDs of roles delegated bot manager privilege in the guild.
+func (context *Context) ManagerRoles() ([]string, error) {
+	if context.IsPrivate || context.Guild == nil {
+		return nil, nil
+	}
+	return context.Multiplexer.managerRoleStore().ManagerRoles(context.Guild.ID)
+}
+
+// SetManagerRoles delegates bot manager privilege to roles of the guild, replacing previous ones.
+// Only guild administrators and operators may delegate.
+func (context *Context) SetManagerRoles(roleIDs ...string) error {
+	if context.IsPrivate || context.Guild == nil {
+		return ErrPermissionDenied
+	}
+	if !context.IsGuildAdministrator() && !context.IsOperator() {
+		return ErrPermissionDenied
+	}
+	for _, roleID := range roleIDs {
+		found := false
+		for _, role := range context.Guild.Roles {
+			if role.ID == roleID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrRoleNotFound
+		}
+	}
+	return context.Multiplexer.managerRoleStore().SetManagerRoles(context.Guild.ID, roleIDs)
+}
@@ -0,0 +1,132 @@
+package multiplexer
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"testing"
+)
+
+func TestUnconfiguredPrivileges(t *testing.T) {
+	for name, mux := range map[string]*Multiplexer{
+		"literal": {},
+		"options": func() *Multiplexer {
+			mux, err := New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return mux
+		}(),
+	} {
+		if mux.IsAdministrator("1") {
+			t.Errorf("%s: unexpected administrator", name)
+		}
+		if mux.IsOperator("1") {
+			t.Errorf("%s: unexpected operator", name)
+		}
+		if mux.IsOperator("") || mux.IsAdministrator("") {
+			t.Errorf("%s: empty ID is privileged", name)
+		}
+
+		context := &Context{
+			Multiplexer: mux,
+			User:        &discordgo.User{ID: "1"},
+			Message:     &discordgo.Message{ChannelID: "2"},
+			Session:     &discordgo.Session{State: discordgo.NewState()},
+		}
+		if context.HasPermission(discordgo.PermissionAdministrator) {
+			t.Errorf("%s: unexpected permission", name)
+		}
+		if context.IsOperator() || context.IsAdministrator() {
+			t.Errorf("%s: context unexpectedly privileged", name)
+		}
+
+		context.User = nil
+		if context.HasPermission(0) || context.IsOperator() || context.IsAdministrator() {
+			t.Errorf("%s: context without user is privileged", name)
+		}
+	}
+}
+
+func TestPrivilegeSets(t *testing.T) {
+	mux, err := New(WithAdministrators("1", "2"), WithOperators("3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string][2]bool{
+		"1": {true, true},
+		"2": {true, true},
+		"3": {false, true},
+		"4": {false, false},
+	} {
+		if got := mux.IsAdministrator(id); got != want[0] {
+			t.Errorf("IsAdministrator(%s) = %v, want %v", id, got, want[0])
+		}
+		if got := mux.IsOperator(id); got != want[1] {
+			t.Errorf("IsOperator(%s) = %v, want %v", id, got, want[1])
+		}
+	}
+
+	mux.Operators().Remove("3")
+	if mux.IsOperator("3") {
+		t.Error("removed operator is still an operator")
+	}
+
+	if _, err = New(WithAdministrators("")); err == nil {
+		t.Error("empty administrator ID accepted")
+	}
+}
+
+func TestIDSetNil(t *testing.T) {
+	var set *IDSet
+	set.Add("1")
+	set.Remove("1")
+	if set.Has("1") || set.Len() != 0 || set.Slice() != nil {
+		t.Error("nil set is not empty")
+	}
+}
+
+type memoryOperatorStore struct {
+	saved []string
+}
+
+func (store *memoryOperatorStore) LoadOperators() ([]string, error) { return store.saved, nil }
+
+func (store *memoryOperatorStore) SaveOperators(ids []string) error {
+	store.saved = ids
+	return nil
+}
+
+func TestOperatorPersistence(t *testing.T) {
+	store := &memoryOperatorStore{}
+	mux, err := New(WithOperators("100000000000000001"), WithOperatorStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mux.AddOperator("", "100000000000000002"); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 1 || store.saved[0] != "100000000000000002" {
+		t.Errorf("configured operators persisted, %v", store.saved)
+	}
+	if err = mux.RemoveOperator("", "100000000000000001"); err != nil {
+		t.Fatal(err)
+	}
+	if mux.IsOperator("100000000000000001") || len(store.saved) != 1 {
+		t.Errorf("configured operator not revoked or store changed, %v", store.saved)
+	}
+}
+
+func TestParseUserID(t *testing.T) {
+	for query, valid := range map[string]bool{
+		"100000000000000001":     true,
+		"<@100000000000000001>":  true,
+		"<@!100000000000000001>": true,
+		"<@&100000000000000001>": false,
+		"<#100000000000000001>":  false,
+		"abc100000000000000001":  false,
+		"1":                      false,
+	} {
+		if _, ok := parseUserID(query); ok != valid {
+			t.Errorf("%q: expected valid %v", query, valid)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package multiplexer
+
+// ErrorReport describes an error returned by a hook or passed to HandleError.
+type ErrorReport struct {
+	Err error
+	// EventType is the event the error occurred in, EventCommand for routes.
+	EventType EventType
+	// Route is the route the error occurred in, nil outside routes.
+	Route *Route
+	// Hook is the name of the hook that returned the error, empty if passed to HandleError.
+	Hook string
+}
+
+// ErrorReporter handles errors of routes and hooks.
+type ErrorReporter func(context *Context, report ErrorReport)
+
+// DefaultErrorReporter logs the error and, unless it was returned by a hook, replies with ErrorOccurred
+// and the error itself if the logger of the multiplexer logs debug messages.
+func DefaultErrorReporter(context *Context, report ErrorReport) {
+	if report.Hook != "" {
+		context.Multiplexer.Logger().Errorf("Error occurred in %s hook %s, %s", report.EventType, report.Hook, report.Err)
+		return
+	}
+	context.Multiplexer.Logger().Errorf("Error occurred while handling Discord route, %s", report.Err)
+	context.SendMessage(ErrorOccurred)
+	if context.Multiplexer.debugEnabled() {
+		context.SendMessage(report.Err.Error())
+	}
+}
+
+// reportError passes an error to the configured reporter.
+func (mux *Multiplexer) reportError(context *Context, report ErrorReport) {
+	if mux.errorReporter == nil {
+		DefaultErrorReporter(context, report)
+		return
+	}
+	mux.errorReporter(context, report)
+}
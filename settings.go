@@ -0,0 +1,181 @@
+package multiplexer
+
+import "sync"
+
+// Keys of settings stored by Settings.
+const (
+	PrefixKey       = "prefix"
+	ManagerRolesKey = "manager_roles"
+	FeaturesKey     = "features"
+	OverridesKey    = "overrides"
+	OperatorsKey    = "operators"
+	BlocklistKey    = "blocklist"
+	WizardsKey      = "wizards"
+)
+
+// Settings implements PrefixStore, ManagerRoleStore, FeatureStore, OverrideStore,
+// OperatorStore, BlocklistStore and WizardStore on top of a Store.
+// Guild prefixes are cached and invalidated through Store.Watch.
+type Settings struct {
+	store    Store
+	logger   Logger
+	cancel   func()
+	closing  sync.Once
+	mutex    sync.RWMutex
+	prefixes map[string]string
+	// generation is incremented on invalidation so lookups racing with a change are not cached.
+	generation uint64
+}
+
+// NewSettings returns Settings backed by store.
+func NewSettings(store Store) *Settings {
+	settings := &Settings{
+		store:    store,
+		prefixes: make(map[string]string),
+	}
+	settings.cancel = store.Watch(settings.invalidate)
+	return settings
+}
+
+// Store returns the backing Store.
+func (settings *Settings) Store() Store {
+	return settings.store
+}
+
+// Close stops watching the backing Store for changes, it is safe to call more than once.
+func (settings *Settings) Close() {
+	settings.closing.Do(settings.cancel)
+}
+
+// invalidate drops cached values of a changed key.
+func (settings *Settings) invalidate(change Change) {
+	if change.Scope != ScopeGuild || change.Key != PrefixKey {
+		return
+	}
+	settings.mutex.Lock()
+	defer settings.mutex.Unlock()
+	delete(settings.prefixes, change.ID)
+	settings.generation++
+}
+
+// log returns the logger of the multiplexer using the settings, the package-level logger if none.
+func (settings *Settings) log() Logger {
+	if settings.logger == nil {
+		return defaultLogger{}
+	}
+	return settings.logger
+}
+
+// GuildPrefix returns the prefix of a guild and whether one is set, errors of the store are logged.
+func (settings *Settings) GuildPrefix(guildID string) (string, bool) {
+	settings.mutex.RLock()
+	prefix, ok := settings.prefixes[guildID]
+	generation := settings.generation
+	settings.mutex.RUnlock()
+	if ok {
+		return prefix, prefix != ""
+	}
+
+	if _, err := settings.store.Get(ScopeGuild, guildID, PrefixKey, &prefix); err != nil {
+		settings.log().Errorf("Error getting prefix of guild %s, %s", guildID, err)
+		return "", false
+	}
+	settings.mutex.Lock()
+	if settings.generation == generation {
+		settings.prefixes[guildID] = prefix
+	}
+	settings.mutex.Unlock()
+	return prefix, prefix != ""
+}
+
+// SetGuildPrefix sets the prefix of a guild, an empty prefix restores the default.
+func (settings *Settings) SetGuildPrefix(guildID, prefix string) error {
+	if prefix == "" {
+		return settings.store.Delete(ScopeGuild, guildID, PrefixKey)
+	}
+	return settings.store.Set(ScopeGuild, guildID, PrefixKey, prefix)
+}
+
+// ManagerRoles returns IDs of manager roles of a guild.
+func (settings *Settings) ManagerRoles(guildID string) ([]string, error) {
+	var roleIDs []string
+	_, err := settings.store.Get(ScopeGuild, guildID, ManagerRolesKey, &roleIDs)
+	return roleIDs, err
+}
+
+// SetManagerRoles replaces manager roles of a guild.
+func (settings *Settings) SetManagerRoles(guildID string, roleIDs []string) error {
+	if len(roleIDs) == 0 {
+		return settings.store.Delete(ScopeGuild, guildID, ManagerRolesKey)
+	}
+	return settings.store.Set(ScopeGuild, guildID, ManagerRolesKey, roleIDs)
+}
+
+// Features returns feature rules of an entity, nil if none are set.
+func (settings *Settings) Features(scope Scope, id string) (*FeatureRules, error) {
+	var rules FeatureRules
+	ok, err := settings.store.Get(scope, id, FeaturesKey, &rules)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// SetFeatures replaces feature rules of an entity, nil clears them.
+func (settings *Settings) SetFeatures(scope Scope, id string, rules *FeatureRules) error {
+	if rules == nil {
+		return settings.store.Delete(scope, id, FeaturesKey)
+	}
+	return settings.store.Set(scope, id, FeaturesKey, rules)
+}
+
+// Overrides returns overrides of a guild.
+func (settings *Settings) Overrides(guildID string) ([]Override, error) {
+	var overrides []Override
+	_, err := settings.store.Get(ScopeGuild, guildID, OverridesKey, &overrides)
+	return overrides, err
+}
+
+// SetOverrides replaces overrides of a guild.
+func (settings *Settings) SetOverrides(guildID string, overrides []Override) error {
+	if len(overrides) == 0 {
+		return settings.store.Delete(ScopeGuild, guildID, OverridesKey)
+	}
+	return settings.store.Set(ScopeGuild, guildID, OverridesKey, overrides)
+}
+
+// LoadOperators returns persisted operator IDs.
+func (settings *Settings) LoadOperators() ([]string, error) {
+	var ids []string
+	_, err := settings.store.Get(ScopeGlobal, "", OperatorsKey, &ids)
+	return ids, err
+}
+
+// SaveOperators replaces persisted operator IDs.
+func (settings *Settings) SaveOperators(ids []string) error {
+	return settings.store.Set(ScopeGlobal, "", OperatorsKey, ids)
+}
+
+// LoadBlocklist returns persisted blocklist entries.
+func (settings *Settings) LoadBlocklist() ([]BlockEntry, error) {
+	var entries []BlockEntry
+	_, err := settings.store.Get(ScopeGlobal, "", BlocklistKey, &entries)
+	return entries, err
+}
+
+// SaveBlocklist replaces persisted blocklist entries.
+func (settings *Settings) SaveBlocklist(entries []BlockEntry) error {
+	return settings.store.Set(ScopeGlobal, "", BlocklistKey, entries)
+}
+
+// LoadWizards returns persisted progress of wizards.
+func (settings *Settings) LoadWizards() ([]WizardState, error) {
+	var states []WizardState
+	_, err := settings.store.Get(ScopeGlobal, "", WizardsKey, &states)
+	return states, err
+}
+
+// SaveWizards replaces persisted progress of wizards.
+func (settings *Settings) SaveWizards(states []WizardState) error {
+	return settings.store.Set(ScopeGlobal, "", WizardsKey, states)
+}
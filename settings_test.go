@@ -0,0 +1,52 @@
+package multiplexer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// failingStore is a Store whose reads fail once failing is set.
+type failingStore struct {
+	*MemoryStore
+	failing bool
+}
+
+func (store *failingStore) Get(scope Scope, id, key string, value interface{}) (bool, error) {
+	if store.failing {
+		return false, errors.New("store unavailable")
+	}
+	return store.MemoryStore.Get(scope, id, key, value)
+}
+
+// recordingLogger records warning and error messages.
+type recordingLogger struct {
+	defaultLogger
+	warnings []string
+	errors   []string
+}
+
+func (logger *recordingLogger) Warnf(format string, args ...interface{}) {
+	logger.warnings = append(logger.warnings, fmt.Sprintf(format, args...))
+}
+
+func (logger *recordingLogger) Errorf(format string, args ...interface{}) {
+	logger.errors = append(logger.errors, fmt.Sprintf(format, args...))
+}
+
+func TestGuildPrefixStoreError(t *testing.T) {
+	logger := &recordingLogger{}
+	store := &failingStore{MemoryStore: NewMemoryStore()}
+	mux, err := New(WithStore(store), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.failing = true
+	defer mux.Settings().Close()
+	if prefix, ok := mux.Settings().GuildPrefix("guild"); ok || prefix != "" {
+		t.Errorf("prefix %q returned from a failing store", prefix)
+	}
+	if len(logger.errors) != 1 {
+		t.Errorf("store error not logged, %q", logger.errors)
+	}
+}
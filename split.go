@@ -0,0 +1,203 @@
+package multiplexer
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"strings"
+	"unicode/utf8"
+)
+
+// MessageLimit is the maximum length of a message in characters.
+const MessageLimit = 2000
+
+// DefaultMaxChunks is the amount of messages long text is split into at most if not configured.
+const DefaultMaxChunks = 5
+
+// DefaultAttachmentName is the name of files long text is uploaded as if not configured.
+const DefaultAttachmentName = "message.txt"
+
+// codeFence delimits code blocks.
+const codeFence = "```"
+
+// SplitConfig configures how text too long for a single message is sent.
+type SplitConfig struct {
+	// MaxChunks is the amount of messages text is split into at most, DefaultMaxChunks if zero.
+	// Text needing more is uploaded as a file, or cut off with MessageTruncated appended without
+	// permission to attach files.
+	MaxChunks int
+	// AttachAbove uploads text longer than this many characters as a file instead of splitting it, never if zero.
+	AttachAbove int
+	// FileName is the name of uploaded files, DefaultAttachmentName if empty.
+	FileName string
+}
+
+// validate checks if the configuration is valid.
+func (config SplitConfig) validate() error {
+	if config.MaxChunks < 0 {
+		return fmt.Errorf("%w: negative maximum message chunks", ErrInvalidOption)
+	}
+	if config.AttachAbove < 0 {
+		return fmt.Errorf("%w: negative message attachment threshold", ErrInvalidOption)
+	}
+	return nil
+}
+
+// withDefaults returns the configuration with unset fields defaulted.
+func (config SplitConfig) withDefaults() SplitConfig {
+	if config.MaxChunks == 0 {
+		config.MaxChunks = DefaultMaxChunks
+	}
+	if config.FileName == "" {
+		config.FileName = DefaultAttachmentName
+	}
+	return config
+}
+
+// SplitMessage splits text into chunks of at most limit characters, breaking at line then word boundaries.
+// Code blocks open at the end of a chunk are closed and reopened with the same language in the next one.
+// Code blocks opened at the very end of a chunk are opened in the next one instead.
+func SplitMessage(text string, limit int) []string {
+	var (
+		chunks  []string
+		chunk   strings.Builder
+		length  int
+		fence   string
+		written bool
+		// opened is where in the chunk the current code block opened if nothing was written since, -1 otherwise.
+		opened = -1
+	)
+	reserve := utf8.RuneCountInString("\n" + codeFence)
+	flush := func() {
+		content := strings.TrimRight(chunk.String(), "\n")
+		if opened >= 0 {
+			content = strings.TrimRight(chunk.String()[:opened], "\n")
+			written = strings.TrimSpace(content) != ""
+		} else if fence != "" {
+			content += "\n" + codeFence
+		}
+		if written {
+			chunks = append(chunks, content)
+		}
+		chunk.Reset()
+		length, written, opened = 0, false, -1
+		if fence != "" {
+			chunk.WriteString(fence + "\n")
+			length = utf8.RuneCountInString(fence) + 1
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		// Room of a chunk holding only the reopened code block
+		room := limit - reserve
+		if fence != "" {
+			room -= utf8.RuneCountInString(fence) + 1
+		}
+		for _, piece := range splitLine(line, room) {
+			size := utf8.RuneCountInString(piece)
+			if length+size+reserve > limit {
+				flush()
+			}
+			chunk.WriteString(piece)
+			length += size
+			written, opened = true, -1
+		}
+
+		// Track code blocks opened and closed by this line
+		trimmed := strings.TrimSpace(line)
+		if strings.Count(trimmed, codeFence)%2 == 0 {
+			continue
+		}
+		if fence != "" {
+			fence = ""
+			continue
+		}
+		fence = trimmed[strings.LastIndex(trimmed, codeFence):]
+		if strings.ContainsAny(fence, " \t") {
+			fence = codeFence
+		}
+		// The line is not in the chunk as a whole if it was split across chunks
+		if start := chunk.Len() - len(line) + strings.LastIndex(line, codeFence); start >= 0 {
+			opened = start
+		}
+	}
+	flush()
+	return chunks
+}
+
+// splitLine splits a line into pieces of at most room characters at spaces, cutting words longer than room.
+func splitLine(line string, room int) []string {
+	if room < 1 {
+		room = 1
+	}
+	if utf8.RuneCountInString(line) <= room {
+		return []string{line}
+	}
+	var pieces []string
+	var piece strings.Builder
+	var length int
+	for _, word := range strings.SplitAfter(line, " ") {
+		size := utf8.RuneCountInString(word)
+		if length+size > room && length > 0 {
+			pieces = append(pieces, piece.String())
+			piece.Reset()
+			length = 0
+		}
+		for size > room {
+			runes := []rune(word)
+			pieces = append(pieces, string(runes[:room]))
+			word = string(runes[room:])
+			size -= room
+		}
+		piece.WriteString(word)
+		length += size
+	}
+	if length > 0 {
+		pieces = append(pieces, piece.String())
+	}
+	return pieces
+}
+
+// sendText sends text in a channel, split into several messages or uploaded as a file if too long,
+// and returns the last message sent.
+func (mux *Multiplexer) sendText(session *discordgo.Session, channelID, text string, permissions int64) (*discordgo.Message, error) {
+	length := utf8.RuneCountInString(text)
+	if length <= MessageLimit {
+		return session.ChannelMessageSend(channelID, text)
+	}
+
+	config := mux.split.withDefaults()
+	canAttach := permissions&discordgo.PermissionAttachFiles == discordgo.PermissionAttachFiles
+	if canAttach && config.AttachAbove > 0 && length > config.AttachAbove {
+		return sendAttachment(session, channelID, config.FileName, text)
+	}
+	chunks := SplitMessage(text, MessageLimit)
+	if len(chunks) > config.MaxChunks {
+		if canAttach {
+			return sendAttachment(session, channelID, config.FileName, text)
+		}
+		chunks = chunks[:config.MaxChunks]
+		notice := "\n" + MessageTruncated
+		last := SplitMessage(chunks[len(chunks)-1], MessageLimit-utf8.RuneCountInString(notice))
+		chunks[len(chunks)-1] = last[0] + notice
+	}
+
+	var message *discordgo.Message
+	for _, chunk := range chunks {
+		var err error
+		if message, err = session.ChannelMessageSend(channelID, chunk); err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+// sendAttachment uploads text as a file in a channel.
+func sendAttachment(session *discordgo.Session, channelID, name, text string) (*discordgo.Message, error) {
+	return session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Files: []*discordgo.File{{
+			Name:        name,
+			ContentType: "text/plain",
+			Reader:      strings.NewReader(text),
+		}},
+	})
+}
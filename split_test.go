@@ -0,0 +1,66 @@
+package multiplexer
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMessage(t *testing.T) {
+	for name, text := range map[string]string{
+		"lines":     strings.Repeat("a line of text\n", 40),
+		"words":     strings.Repeat("word ", 100),
+		"long word": strings.Repeat("ä", 250),
+		"code":      "intro\n```go\n" + strings.Repeat("fmt.Println(\"hello\")\n", 30) + "```\noutro",
+		"late code": strings.Repeat("x", 80) + "\n```go\n" + strings.Repeat("y", 30) + "\n```",
+	} {
+		chunks := SplitMessage(text, 100)
+		if len(chunks) < 2 {
+			t.Errorf("%s: not split, %d chunks", name, len(chunks))
+		}
+		for i, chunk := range chunks {
+			if length := utf8.RuneCountInString(chunk); length > 100 {
+				t.Errorf("%s: chunk %d has %d characters", name, i, length)
+			}
+			if strings.Count(chunk, "```")%2 != 0 {
+				t.Errorf("%s: chunk %d leaves a code block open", name, i)
+			}
+			if strings.HasSuffix(chunk, "```go\n```") {
+				t.Errorf("%s: chunk %d holds an empty code block", name, i)
+			}
+		}
+		if joined := splitContent(strings.Join(chunks, "\n")); joined != splitContent(text) {
+			t.Errorf("%s: chunks joined back do not equal the text, %q", name, joined)
+		}
+		if name == "code" {
+			if !strings.HasPrefix(chunks[1], "```go\n") {
+				t.Errorf("code block not reopened with its language, %q", chunks[1])
+			}
+			if !strings.HasSuffix(chunks[len(chunks)-1], "outro") {
+				t.Errorf("text after code block lost, %q", chunks[len(chunks)-1])
+			}
+		}
+		if name == "words" {
+			for i, chunk := range chunks {
+				if strings.Contains(strings.TrimSpace(chunk), "wo ") || !strings.HasSuffix(strings.TrimSpace(chunk), "word") {
+					t.Errorf("words: chunk %d breaks a word, %q", i, chunk)
+				}
+			}
+		}
+	}
+
+	if chunks := SplitMessage("short", 100); len(chunks) != 1 || chunks[0] != "short" {
+		t.Errorf("short text changed, %q", chunks)
+	}
+}
+
+// splitContent returns text without code fence lines and whitespace, which SplitMessage adds and drops at chunk boundaries.
+func splitContent(text string) string {
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), codeFence) {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(strings.Fields(strings.Join(kept, "\n")), "")
+}
@@ -0,0 +1,193 @@
+package multiplexer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// ScopeGlobal is the scope of settings not bound to an entity, its ID is empty.
+const ScopeGlobal Scope = "global"
+
+// Change describes a change of a key in a Store.
+type Change struct {
+	Scope   Scope
+	ID      string
+	Key     string
+	Deleted bool
+}
+
+// Store is a key/value store of settings scoped to guilds, channels, users or globally.
+// Values are encoded as JSON, so any type encoding/json handles may be stored.
+type Store interface {
+	// Get decodes the value of a key into value, which must be a pointer, and returns whether the key exists.
+	Get(scope Scope, id, key string, value interface{}) (bool, error)
+	// Set stores the value of a key.
+	Set(scope Scope, id, key string, value interface{}) error
+	// Delete removes a key.
+	Delete(scope Scope, id, key string) error
+	// Watch registers a function called after each change and returns a function unregistering it.
+	Watch(watcher func(change Change)) (cancel func())
+}
+
+// storeData is the layout of stored values, indexed by scope, ID then key.
+type storeData map[Scope]map[string]map[string]json.RawMessage
+
+// MemoryStore is a Store holding values in memory.
+type MemoryStore struct {
+	mutex    sync.RWMutex
+	data     storeData
+	persist  func(data storeData) error
+	watchers map[int]func(change Change)
+	watcher  int
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Get decodes the value of a key into value and returns whether the key exists.
+func (store *MemoryStore) Get(scope Scope, id, key string, value interface{}) (bool, error) {
+	store.mutex.RLock()
+	raw, ok := store.data[scope][id][key]
+	store.mutex.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, value)
+}
+
+// Set stores the value of a key.
+func (store *MemoryStore) Set(scope Scope, id, key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	err = store.update(scope, id, key, raw)
+	if err == nil {
+		store.notify(Change{Scope: scope, ID: id, Key: key})
+	}
+	return err
+}
+
+// Delete removes a key.
+func (store *MemoryStore) Delete(scope Scope, id, key string) error {
+	err := store.update(scope, id, key, nil)
+	if err == nil {
+		store.notify(Change{Scope: scope, ID: id, Key: key, Deleted: true})
+	}
+	return err
+}
+
+// update replaces or removes a raw value and persists, reverting if persisting fails.
+func (store *MemoryStore) update(scope Scope, id, key string, raw json.RawMessage) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	previous, existed := store.data[scope][id][key]
+	store.put(scope, id, key, raw)
+	if store.persist == nil {
+		return nil
+	}
+	if err := store.persist(store.data); err != nil {
+		if existed {
+			store.put(scope, id, key, previous)
+		} else {
+			store.put(scope, id, key, nil)
+		}
+		return err
+	}
+	return nil
+}
+
+// put replaces a raw value, or removes it if nil, pruning empty maps.
+func (store *MemoryStore) put(scope Scope, id, key string, raw json.RawMessage) {
+	if raw == nil {
+		delete(store.data[scope][id], key)
+		if len(store.data[scope][id]) == 0 {
+			delete(store.data[scope], id)
+		}
+		if len(store.data[scope]) == 0 {
+			delete(store.data, scope)
+		}
+		return
+	}
+	if store.data == nil {
+		store.data = make(storeData)
+	}
+	if store.data[scope] == nil {
+		store.data[scope] = make(map[string]map[string]json.RawMessage)
+	}
+	if store.data[scope][id] == nil {
+		store.data[scope][id] = make(map[string]json.RawMessage)
+	}
+	store.data[scope][id][key] = raw
+}
+
+// Watch registers a function called after each change and returns a function unregistering it.
+func (store *MemoryStore) Watch(watcher func(change Change)) func() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.watchers == nil {
+		store.watchers = make(map[int]func(change Change))
+	}
+	store.watcher++
+	id := store.watcher
+	store.watchers[id] = watcher
+	return func() {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		delete(store.watchers, id)
+	}
+}
+
+// notify calls watchers with a change.
+func (store *MemoryStore) notify(change Change) {
+	store.mutex.RLock()
+	watchers := make([]func(change Change), 0, len(store.watchers))
+	for _, watcher := range store.watchers {
+		watchers = append(watchers, watcher)
+	}
+	store.mutex.RUnlock()
+	for _, watcher := range watchers {
+		watcher(change)
+	}
+}
+
+// FileStore is a Store holding values in memory and persisting all of them to a single JSON file on every change.
+// Writes replace the file atomically, so a crash never leaves a partially written file.
+type FileStore struct {
+	MemoryStore
+	path string
+}
+
+// OpenFileStore returns a FileStore persisting to the JSON file at path, loading existing values.
+func OpenFileStore(path string) (*FileStore, error) {
+	store := &FileStore{path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, &store.data); err != nil {
+			return nil, err
+		}
+	}
+	store.persist = store.write
+	return store, nil
+}
+
+// Path returns the path of the JSON file.
+func (store *FileStore) Path() string {
+	return store.path
+}
+
+// write atomically replaces the JSON file with data.
+func (store *FileStore) write(data storeData) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.path, encoded)
+}
@@ -6,6 +6,27 @@ const InvalidArgument = "Invalid argument."
 // ErrorOccurred is the message sent when the event handler catches an error.
 const ErrorOccurred = "Something went wrong and I am very confused! Please try again!"
 
+// CommandTimeout is the message sent when a command does not complete before its deadline.
+const CommandTimeout = "This command took too long and was cancelled."
+
+// ConfirmPrompt is the message sent when a route requires confirmation.
+const ConfirmPrompt = "Are you sure? React with ✅ or reply yes to confirm."
+
+// ConfirmCancelled is the message sent when a route requiring confirmation is not confirmed.
+const ConfirmCancelled = "Cancelled."
+
+// WizardInvalidAnswer is the message sent when an answer to a wizard step is invalid.
+const WizardInvalidAnswer = "Invalid answer, please try again, or reply back or cancel."
+
+// WizardCancelled is the message sent when the user cancels a wizard.
+const WizardCancelled = "Cancelled."
+
+// WizardTimedOut is the message sent when an answer to a wizard does not arrive in time.
+const WizardTimedOut = "No answer arrived in time, please start over."
+
+// MessageTruncated is appended to text cut off because it is too long to send.
+const MessageTruncated = "(Message truncated.)"
+
 // GuildOnly is the message sent when a guild-only command is issued in private.
 const GuildOnly = "This command can only be issued from a guild."
 
@@ -0,0 +1,441 @@
+package multiplexer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrWizardActive represents the error returned when a user already runs a wizard in a channel.
+var ErrWizardActive = errors.New("wizard already active")
+
+// ErrWizardCancelled represents the error returned when the user cancels a wizard.
+var ErrWizardCancelled = errors.New("wizard cancelled")
+
+// DefaultWizardTimeout is how long wizards wait for each answer if no timeout is set.
+const DefaultWizardTimeout = 2 * time.Minute
+
+// Answers going back a step or cancelling a wizard.
+const (
+	WizardBack   = "back"
+	WizardCancel = "cancel"
+)
+
+// WizardStep is a prompt of a wizard.
+type WizardStep struct {
+	// Key is the key of the answer in the answers of the wizard.
+	Key string
+	// Prompt is the message asking for the answer.
+	Prompt string
+	// Parse converts an answer to the stored value and returns false if it is invalid.
+	Parse func(context *Context, text string) (string, bool)
+	// Validate checks a parsed value, the text of its error is sent to the user who is asked again.
+	Validate func(context *Context, value string) error
+}
+
+// TextStep returns a step storing the answer as is.
+func TextStep(key, prompt string) WizardStep {
+	return WizardStep{Key: key, Prompt: prompt, Parse: func(context *Context, text string) (string, bool) {
+		return text, text != ""
+	}}
+}
+
+// IntegerStep returns a step storing the answer as an integer.
+func IntegerStep(key, prompt string) WizardStep {
+	return WizardStep{Key: key, Prompt: prompt, Parse: func(context *Context, text string) (string, bool) {
+		value, err := strconv.Atoi(text)
+		return strconv.Itoa(value), err == nil
+	}}
+}
+
+// MemberStep returns a step storing the user ID of a member, parsed like GetMember.
+func MemberStep(key, prompt string) WizardStep {
+	return WizardStep{Key: key, Prompt: prompt, Parse: func(context *Context, text string) (string, bool) {
+		member := context.GetMember(text)
+		if member == nil || member.User == nil {
+			return "", false
+		}
+		return member.User.ID, true
+	}}
+}
+
+// ChannelStep returns a step storing a channel ID, parsed like GetChannel.
+func ChannelStep(key, prompt string) WizardStep {
+	return WizardStep{Key: key, Prompt: prompt, Parse: func(context *Context, text string) (string, bool) {
+		channel := context.GetChannel(text)
+		if channel == nil {
+			return "", false
+		}
+		return channel.ID, true
+	}}
+}
+
+// RoleStep returns a step storing a role ID, parsed like GetRole.
+func RoleStep(key, prompt string) WizardStep {
+	return WizardStep{Key: key, Prompt: prompt, Parse: func(context *Context, text string) (string, bool) {
+		role := context.GetRole(text)
+		if role == nil {
+			return "", false
+		}
+		return role.ID, true
+	}}
+}
+
+// Wizard is a conversation asking a user a series of questions in a channel.
+// Users answer WizardBack to return to the previous step and WizardCancel to stop.
+type Wizard struct {
+	// Name identifies the wizard, persisted wizards are resumed by name.
+	Name string
+	// Steps are the prompts of the wizard in order.
+	Steps []WizardStep
+	// Timeout stops the wizard once an answer has not arrived for this long, DefaultWizardTimeout if zero.
+	Timeout time.Duration
+	// Persist saves progress to the wizard store so the wizard resumes after a restart.
+	Persist bool
+	// Complete is called with the answers by step key once all steps are answered.
+	Complete func(context *Context, answers map[string]string) error
+}
+
+// timeout returns the timeout of the wizard, falling back to the default.
+func (wizard *Wizard) timeout() time.Duration {
+	if wizard.Timeout > 0 {
+		return wizard.Timeout
+	}
+	return DefaultWizardTimeout
+}
+
+// WizardState is the progress of a wizard run by a user in a channel.
+type WizardState struct {
+	Wizard    string            `json:"wizard"`
+	UserID    string            `json:"user_id"`
+	ChannelID string            `json:"channel_id"`
+	Step      int               `json:"step"`
+	Answers   map[string]string `json:"answers,omitempty"`
+	Updated   time.Time         `json:"updated"`
+}
+
+// key returns the key of the user and channel of the state.
+func (state *WizardState) key() string {
+	return state.UserID + ":" + state.ChannelID
+}
+
+// clone returns a copy of the state not sharing answers.
+func (state *WizardState) clone() *WizardState {
+	copied := *state
+	copied.Answers = make(map[string]string, len(state.Answers))
+	for key, value := range state.Answers {
+		copied.Answers[key] = value
+	}
+	return &copied
+}
+
+// WizardStore persists progress of wizards.
+type WizardStore interface {
+	// LoadWizards returns persisted states.
+	LoadWizards() ([]WizardState, error)
+	// SaveWizards replaces persisted states.
+	SaveWizards(states []WizardState) error
+}
+
+// wizardRegistry tracks wizards by name and their runs by user and channel.
+type wizardRegistry struct {
+	mutex   sync.Mutex
+	wizards map[string]*Wizard
+	// states holds progress of persisted wizards, including ones not running since a restart.
+	states  map[string]*WizardState
+	running map[string]bool
+	// saving serializes saves so states are persisted in the order they were copied.
+	saving sync.Mutex
+}
+
+// register makes a wizard resumable by name.
+func (registry *wizardRegistry) register(wizard *Wizard) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.wizards == nil {
+		registry.wizards = make(map[string]*Wizard)
+	}
+	registry.wizards[wizard.Name] = wizard
+}
+
+// begin marks a run as started unless one is running or persisted for the same user and channel.
+// Persisted runs of wizards not registered or expired are dropped, changed reports whether persisted states changed.
+func (registry *wizardRegistry) begin(key string, now time.Time) (ok, changed bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.running[key] {
+		return false, false
+	}
+	if state := registry.states[key]; state != nil {
+		if !registry.stale(state, now) {
+			return false, false
+		}
+		delete(registry.states, key)
+		changed = true
+	}
+	if registry.running == nil {
+		registry.running = make(map[string]bool)
+	}
+	registry.running[key] = true
+	return true, changed
+}
+
+// stale checks if a persisted run belongs to a wizard not registered or has expired, the mutex must be held.
+func (registry *wizardRegistry) stale(state *WizardState, now time.Time) bool {
+	wizard := registry.wizards[state.Wizard]
+	return wizard == nil || now.Sub(state.Updated) > wizard.timeout()
+}
+
+// prune drops persisted runs not running that are stale and returns whether persisted states changed.
+func (registry *wizardRegistry) prune(now time.Time) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	changed := false
+	for key, state := range registry.states {
+		if !registry.running[key] && registry.stale(state, now) {
+			delete(registry.states, key)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// resume marks a persisted run not running as started and returns it with its wizard.
+// Runs of wizards not registered are left alone until pruned, expired runs are dropped.
+func (registry *wizardRegistry) resume(key string, now time.Time) (*Wizard, *WizardState, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	state := registry.states[key]
+	if state == nil || registry.running[key] {
+		return nil, nil, false
+	}
+	wizard := registry.wizards[state.Wizard]
+	if wizard == nil {
+		return nil, nil, false
+	}
+	if now.Sub(state.Updated) > wizard.timeout() {
+		delete(registry.states, key)
+		return nil, nil, true
+	}
+	if registry.running == nil {
+		registry.running = make(map[string]bool)
+	}
+	registry.running[key] = true
+	return wizard, state.clone(), true
+}
+
+// update records progress of a run and returns whether persisted states changed.
+func (registry *wizardRegistry) update(state *WizardState, persist bool) bool {
+	if !persist {
+		return false
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.states == nil {
+		registry.states = make(map[string]*WizardState)
+	}
+	registry.states[state.key()] = state.clone()
+	return true
+}
+
+// end marks a run as stopped, dropping its progress unless keep is set, and returns whether persisted states changed.
+func (registry *wizardRegistry) end(key string, keep bool) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	delete(registry.running, key)
+	if keep || registry.states[key] == nil {
+		return false
+	}
+	delete(registry.states, key)
+	return true
+}
+
+// RegisterWizard makes a persisted wizard resumable after a restart, it must be registered before
+// the session opens as progress of wizards not registered once it is ready is dropped.
+// Wizards started with RunWizard are registered automatically.
+func (mux *Multiplexer) RegisterWizard(wizard *Wizard) {
+	mux.wizards.register(wizard)
+}
+
+// loadWizards loads persisted progress of wizards, skipping states not naming a wizard, user and channel.
+// Wizards are registered after loading, so states of unknown or expired wizards are pruned once the session is ready.
+func (mux *Multiplexer) loadWizards() error {
+	if mux.wizardStore == nil {
+		return nil
+	}
+	states, err := mux.wizardStore.LoadWizards()
+	if err != nil {
+		return err
+	}
+	mux.wizards.mutex.Lock()
+	defer mux.wizards.mutex.Unlock()
+	mux.wizards.states = make(map[string]*WizardState, len(states))
+	for i := range states {
+		if states[i].Wizard == "" || states[i].UserID == "" || states[i].ChannelID == "" {
+			continue
+		}
+		mux.wizards.states[states[i].key()] = &states[i]
+	}
+	return nil
+}
+
+// pruneWizards drops persisted progress of wizards not registered or expired.
+func (mux *Multiplexer) pruneWizards() {
+	if mux.wizardStore != nil && mux.wizards.prune(time.Now()) {
+		mux.saveWizards()
+	}
+}
+
+// saveWizards persists progress of wizards, the store is called without holding the registry mutex.
+func (mux *Multiplexer) saveWizards() {
+	if mux.wizardStore == nil {
+		return
+	}
+	mux.wizards.saving.Lock()
+	defer mux.wizards.saving.Unlock()
+	mux.wizards.mutex.Lock()
+	states := make([]WizardState, 0, len(mux.wizards.states))
+	for _, state := range mux.wizards.states {
+		states = append(states, *state.clone())
+	}
+	mux.wizards.mutex.Unlock()
+	if err := mux.wizardStore.SaveWizards(states); err != nil {
+		mux.Logger().Warnf("Error saving wizards, %s", err)
+	}
+}
+
+// RunWizard runs a wizard for the context user in the current channel, blocking until it completes.
+// It returns ErrWizardActive if the user already runs a wizard in the channel, ErrWizardCancelled if
+// the user cancels, ErrAwaitTimeout if an answer did not arrive in time, or the error of Complete.
+func (context *Context) RunWizard(wizard *Wizard) error {
+	if context.User == nil {
+		return ErrUserNotFound
+	}
+	mux := context.Multiplexer
+	mux.RegisterWizard(wizard)
+	state := &WizardState{
+		Wizard:    wizard.Name,
+		UserID:    context.User.ID,
+		ChannelID: context.channelID(),
+		Answers:   make(map[string]string),
+	}
+	ok, changed := mux.wizards.begin(state.key(), time.Now())
+	if changed {
+		mux.saveWizards()
+	}
+	if !ok {
+		return ErrWizardActive
+	}
+	return mux.runWizard(context, wizard, state, nil)
+}
+
+// runWizard asks the remaining steps of a wizard, taking answer as the answer to the current step if not nil.
+func (mux *Multiplexer) runWizard(context *Context, wizard *Wizard, state *WizardState, answer *discordgo.Message) error {
+	keep := false
+	defer func() {
+		if mux.wizards.end(state.key(), keep) {
+			mux.saveWizards()
+		}
+	}()
+
+	prompt := answer == nil
+	for state.Step < len(wizard.Steps) {
+		step := wizard.Steps[state.Step]
+		if answer == nil {
+			if prompt && context.SendMessage(step.Prompt) == nil {
+				return ErrMessageNotSent
+			}
+			var err error
+			answer, err = context.AwaitMessage(nil, wizard.timeout())
+			switch err {
+			case nil:
+			case ErrAwaitTimeout:
+				context.SendMessage(WizardTimedOut)
+				return err
+			default:
+				// Progress is kept for the wizard to resume after a restart
+				keep = true
+				return err
+			}
+		}
+		text := strings.TrimSpace(answer.Content)
+		answer, prompt = nil, true
+
+		switch strings.ToLower(text) {
+		case WizardCancel:
+			context.SendMessage(WizardCancelled)
+			return ErrWizardCancelled
+		case WizardBack:
+			if state.Step > 0 {
+				state.Step--
+				delete(state.Answers, wizard.Steps[state.Step].Key)
+			}
+		default:
+			value, ok := step.Parse(context, text)
+			if !ok {
+				context.SendMessage(WizardInvalidAnswer)
+				prompt = false
+				continue
+			}
+			if step.Validate != nil {
+				if err := step.Validate(context, value); err != nil {
+					context.SendMessage(err.Error())
+					prompt = false
+					continue
+				}
+			}
+			state.Answers[step.Key] = value
+			state.Step++
+		}
+		state.Updated = time.Now()
+		if state.Step < len(wizard.Steps) && mux.wizards.update(state, wizard.Persist) {
+			mux.saveWizards()
+		}
+	}
+
+	if wizard.Complete == nil {
+		return nil
+	}
+	return wizard.Complete(context, state.Answers)
+}
+
+// resumeWizard resumes a persisted wizard of the author in the channel of a message, taking the message as the answer
+// to its current step, and returns whether one was resumed. Runs not dispatched are marked as stopped, keeping their progress.
+func (mux *Multiplexer) resumeWizard(session *discordgo.Session, create *discordgo.MessageCreate) bool {
+	key := create.Author.ID + ":" + create.ChannelID
+	wizard, state, changed := mux.wizards.resume(key, time.Now())
+	if changed && wizard == nil {
+		mux.saveWizards()
+	}
+	if wizard == nil {
+		return false
+	}
+
+	accepted := mux.dispatch(EventCommand, func() {
+		context := mux.NewContextMessage(session, create.Message, create)
+		if context == nil {
+			mux.wizards.end(key, true)
+			return
+		}
+		context.eventType = EventCommand
+		var cancel func()
+		context.ctx, cancel = mux.invocationContext(0)
+		defer cancel()
+		err := mux.runWizard(context, wizard, state, create.Message)
+		switch err {
+		case nil, ErrWizardCancelled, ErrAwaitTimeout, ErrAwaitCancelled, ErrMessageNotSent:
+		default:
+			if context.Err() == nil {
+				mux.reportError(context, ErrorReport{Err: fmt.Errorf("wizard %s: %w", wizard.Name, err), EventType: EventCommand})
+			}
+		}
+	})
+	if !accepted {
+		mux.wizards.end(key, true)
+	}
+	return accepted
+}
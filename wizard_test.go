@@ -0,0 +1,141 @@
+package multiplexer
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"testing"
+	"time"
+)
+
+type memoryWizardStore struct {
+	saved []WizardState
+}
+
+func (store *memoryWizardStore) LoadWizards() ([]WizardState, error) { return store.saved, nil }
+
+func (store *memoryWizardStore) SaveWizards(states []WizardState) error {
+	store.saved = states
+	return nil
+}
+
+func TestWizardRegistry(t *testing.T) {
+	now := time.Now()
+	registry := &wizardRegistry{}
+	registry.register(&Wizard{Name: "setup", Timeout: time.Minute})
+	state := &WizardState{Wizard: "setup", UserID: "user", ChannelID: "channel", Step: 1, Updated: now}
+	key := state.key()
+
+	if !registry.update(state, true) {
+		t.Fatal("persisted progress not recorded")
+	}
+	if ok, _ := registry.begin(key, now); ok {
+		t.Error("run begun over persisted progress")
+	}
+	wizard, resumed, _ := registry.resume(key, now)
+	if wizard == nil || resumed.Step != 1 {
+		t.Fatalf("persisted progress not resumed, %+v", resumed)
+	}
+	if _, _, changed := registry.resume(key, now); changed {
+		t.Error("running wizard resumed twice")
+	}
+	if registry.end(key, true) || registry.states[key] == nil {
+		t.Error("kept progress dropped")
+	}
+	if !registry.end(key, false) || registry.states[key] != nil {
+		t.Error("progress not dropped")
+	}
+
+	// Expired progress and progress of wizards not registered are dropped
+	registry.update(state, true)
+	if ok, changed := registry.begin(key, now.Add(2*time.Minute)); !ok || !changed {
+		t.Errorf("expired progress blocked a run, ok %t changed %t", ok, changed)
+	}
+	registry.end(key, false)
+	registry.update(&WizardState{Wizard: "unknown", UserID: "user", ChannelID: "channel", Updated: now}, true)
+	registry.update(&WizardState{Wizard: "setup", UserID: "other", ChannelID: "channel", Updated: now}, true)
+	if !registry.prune(now) || len(registry.states) != 1 {
+		t.Errorf("%d states kept after pruning, want 1", len(registry.states))
+	}
+}
+
+func TestRunWizard(t *testing.T) {
+	store := &memoryWizardStore{saved: []WizardState{{Wizard: "setup"}}}
+	mux, err := New(WithWizardStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mux.wizards.states) != 0 {
+		t.Error("state without user and channel loaded")
+	}
+	wizard := &Wizard{
+		Name:    "setup",
+		Steps:   []WizardStep{TextStep("name", "Name?"), IntegerStep("age", "Age?")},
+		Persist: true,
+	}
+	mux.RegisterWizard(wizard)
+
+	// Without permission to send prompts, the wizard stops at the first prompt after the answer
+	session := &discordgo.Session{State: discordgo.NewState()}
+	session.State.User = &discordgo.User{ID: "bot"}
+	context := &Context{
+		Multiplexer: mux,
+		Session:     session,
+		User:        &discordgo.User{ID: "user"},
+		Message:     &discordgo.Message{ChannelID: "channel"},
+	}
+	answer := func(state *WizardState, text string) error {
+		mux.wizards.begin(state.key(), time.Now())
+		return mux.runWizard(context, wizard, state, &discordgo.Message{Content: text})
+	}
+
+	state := &WizardState{Wizard: "setup", UserID: "user", ChannelID: "channel", Answers: map[string]string{}}
+	if err = answer(state, "Alice"); err != ErrMessageNotSent {
+		t.Fatalf("answer returned %v", err)
+	}
+	if len(store.saved) != 0 {
+		t.Errorf("progress kept after the wizard stopped, %+v", store.saved)
+	}
+	if state.Step != 1 || state.Answers["name"] != "Alice" {
+		t.Errorf("answer not recorded, %+v", state)
+	}
+
+	if err = answer(state, WizardBack); err != ErrMessageNotSent {
+		t.Fatalf("going back returned %v", err)
+	}
+	if _, ok := state.Answers["name"]; state.Step != 0 || ok {
+		t.Errorf("going back kept the answer, %+v", state)
+	}
+
+	state.Step = 1
+	if err = answer(state, WizardCancel); err != ErrWizardCancelled {
+		t.Errorf("cancelling returned %v", err)
+	}
+	if mux.wizards.running[state.key()] {
+		t.Error("cancelled wizard still running")
+	}
+}
+
+func TestResumeWizardRefused(t *testing.T) {
+	store := &memoryWizardStore{saved: []WizardState{
+		{Wizard: "setup", UserID: "user", ChannelID: "channel", Updated: time.Now()},
+	}}
+	mux, err := New(WithWizardStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.RegisterWizard(&Wizard{Name: "setup", Steps: []WizardStep{TextStep("name", "Name?")}, Persist: true})
+	if err = mux.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	create := &discordgo.MessageCreate{Message: &discordgo.Message{
+		Author:    &discordgo.User{ID: "user"},
+		ChannelID: "channel",
+	}}
+	if mux.resumeWizard(nil, create) {
+		t.Error("wizard resumed during shutdown")
+	}
+	if mux.wizards.running["user:channel"] || mux.wizards.states["user:channel"] == nil {
+		t.Error("refused run left running or lost its progress")
+	}
+}